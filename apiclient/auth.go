@@ -0,0 +1,224 @@
+package apiclient
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+)
+
+// Authenticator adds credentials to each request sent to a broker
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// BasicAuth authenticates with HTTP basic auth username/password
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+// Authenticate sets the Authorization header using basic auth
+func (auth BasicAuth) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(auth.Username, auth.Password)
+	return nil
+}
+
+// BearerToken authenticates with a static, pre-fetched bearer token
+type BearerToken struct {
+	Token string
+}
+
+// Authenticate sets the Authorization header to the bearer token
+func (auth BearerToken) Authenticate(req *http.Request) error {
+	if auth.Token == "" {
+		return fmt.Errorf("No bearer token provided")
+	}
+	req.Header.Set("Authorization", "Bearer "+auth.Token)
+	return nil
+}
+
+// OAuth2ClientCredentials fetches bearer tokens from a UAA or other OAuth2
+// token endpoint using the client credentials grant. Tokens are cached in
+// CacheDir (one file per token URL/client/scopes) and refetched once expired.
+type OAuth2ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	CacheDir     string
+	HTTPClient   *http.Client
+
+	mutex sync.Mutex
+	token *oauth2Token
+}
+
+type oauth2Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// tokens are refetched slightly before they expire to allow for clock skew
+const oauth2ExpiryLeeway = 30 * time.Second
+
+func (token *oauth2Token) valid() bool {
+	return token != nil && token.AccessToken != "" &&
+		(token.ExpiresAt.IsZero() || time.Now().Add(oauth2ExpiryLeeway).Before(token.ExpiresAt))
+}
+
+// Authenticate sets the Authorization header to a cached or newly fetched token
+func (auth *OAuth2ClientCredentials) Authenticate(req *http.Request) error {
+	token, err := auth.currentToken()
+	if err != nil {
+		return err
+	}
+	tokenType := token.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	req.Header.Set("Authorization", tokenType+" "+token.AccessToken)
+	return nil
+}
+
+// currentToken returns a valid access token, from memory, the disk cache or
+// the token endpoint; concurrent requests wait for a single token request
+func (auth *OAuth2ClientCredentials) currentToken() (*oauth2Token, error) {
+	auth.mutex.Lock()
+	defer auth.mutex.Unlock()
+	if auth.token.valid() {
+		return auth.token, nil
+	}
+	if cached := auth.readCachedToken(); cached != nil {
+		if cached.valid() {
+			auth.token = cached
+			return auth.token, nil
+		}
+		if cached.RefreshToken != "" {
+			if token, err := auth.requestToken(url.Values{
+				"grant_type":    {"refresh_token"},
+				"refresh_token": {cached.RefreshToken},
+			}); err == nil {
+				auth.token = token
+				auth.writeCachedToken(token)
+				return token, nil
+			}
+		}
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(auth.Scopes) > 0 {
+		form.Set("scope", strings.Join(auth.Scopes, " "))
+	}
+	token, err := auth.requestToken(form)
+	if err != nil {
+		return nil, err
+	}
+	auth.token = token
+	auth.writeCachedToken(token)
+	return token, nil
+}
+
+func (auth *OAuth2ClientCredentials) requestToken(form url.Values) (*oauth2Token, error) {
+	if auth.TokenURL == "" {
+		return nil, fmt.Errorf("No OAuth2 token URL provided")
+	}
+	req, err := http.NewRequest("POST", auth.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errwrap.Wrapf("Cannot construct token request: {{err}}", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(auth.ClientID), url.QueryEscape(auth.ClientSecret))
+
+	client := auth.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: DefaultTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errwrap.Wrapf("Failed doing token request: {{err}}", err)
+	}
+	defer resp.Body.Close()
+
+	resBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errwrap.Wrapf("Failed reading token response body: {{err}}", err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("Token request error %d: %s", resp.StatusCode, strings.TrimSpace(string(resBody)))
+	}
+
+	var tokenResp struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+	}
+	if err = json.Unmarshal(resBody, &tokenResp); err != nil {
+		return nil, errwrap.Wrapf("Failed unmarshalling token response: {{err}}", err)
+	}
+	if tokenResp.AccessToken == "" {
+		return nil, fmt.Errorf("Token response did not include an access_token")
+	}
+	token := &oauth2Token{
+		AccessToken:  tokenResp.AccessToken,
+		TokenType:    tokenResp.TokenType,
+		RefreshToken: tokenResp.RefreshToken,
+	}
+	if tokenResp.ExpiresIn > 0 {
+		token.ExpiresAt = time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// cachePath is unique per token endpoint, client and requested scopes
+func (auth *OAuth2ClientCredentials) cachePath() string {
+	if auth.CacheDir == "" {
+		return ""
+	}
+	key := sha256.Sum256([]byte(auth.TokenURL + "\n" + auth.ClientID + "\n" + strings.Join(auth.Scopes, " ")))
+	return filepath.Join(auth.CacheDir, fmt.Sprintf("%x.json", key[:8]))
+}
+
+func (auth *OAuth2ClientCredentials) readCachedToken() *oauth2Token {
+	path := auth.cachePath()
+	if path == "" {
+		return nil
+	}
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	token := &oauth2Token{}
+	if err = json.Unmarshal(bytes, token); err != nil {
+		return nil
+	}
+	return token
+}
+
+// writeCachedToken is best effort; a failure only means fetching a new token next time
+func (auth *OAuth2ClientCredentials) writeCachedToken(token *oauth2Token) {
+	path := auth.cachePath()
+	if path == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	bytes, err := json.Marshal(token)
+	if err != nil {
+		return
+	}
+	ioutil.WriteFile(path, bytes, 0600)
+}
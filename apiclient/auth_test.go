@@ -0,0 +1,181 @@
+package apiclient
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestOAuth2TokenValid(t *testing.T) {
+	tests := []struct {
+		name  string
+		token *oauth2Token
+		want  bool
+	}{
+		{"nil", nil, false},
+		{"no access token", &oauth2Token{ExpiresAt: time.Now().Add(time.Hour)}, false},
+		{"no expiry", &oauth2Token{AccessToken: "t"}, true},
+		{"expired", &oauth2Token{AccessToken: "t", ExpiresAt: time.Now().Add(-time.Minute)}, false},
+		{"within leeway", &oauth2Token{AccessToken: "t", ExpiresAt: time.Now().Add(oauth2ExpiryLeeway / 2)}, false},
+		{"valid", &oauth2Token{AccessToken: "t", ExpiresAt: time.Now().Add(time.Hour)}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.token.valid(); got != test.want {
+				t.Errorf("valid() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOAuth2CachePath(t *testing.T) {
+	newAuth := func() *OAuth2ClientCredentials {
+		return &OAuth2ClientCredentials{TokenURL: "https://uaa/oauth/token", ClientID: "client", Scopes: []string{"a"}, CacheDir: "/cache"}
+	}
+	base := newAuth()
+	tests := []struct {
+		name   string
+		change func(auth *OAuth2ClientCredentials)
+		same   bool
+	}{
+		{"same settings", func(auth *OAuth2ClientCredentials) {}, true},
+		{"other secret", func(auth *OAuth2ClientCredentials) { auth.ClientSecret = "other" }, true},
+		{"other token URL", func(auth *OAuth2ClientCredentials) { auth.TokenURL = "https://other/oauth/token" }, false},
+		{"other client", func(auth *OAuth2ClientCredentials) { auth.ClientID = "other" }, false},
+		{"other scopes", func(auth *OAuth2ClientCredentials) { auth.Scopes = []string{"a", "b"} }, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			auth := newAuth()
+			test.change(auth)
+			if same := auth.cachePath() == base.cachePath(); same != test.same {
+				t.Errorf("cachePath() %s, base %s: same = %v, want %v", auth.cachePath(), base.cachePath(), same, test.same)
+			}
+		})
+	}
+	if path := (&OAuth2ClientCredentials{TokenURL: "https://uaa/oauth/token"}).cachePath(); path != "" {
+		t.Errorf("cachePath() without CacheDir = %q, want empty", path)
+	}
+}
+
+func TestOAuth2CurrentToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		cached     *oauth2Token
+		wantToken  string
+		wantGrants []string
+	}{
+		{
+			name:       "nothing cached",
+			wantToken:  "token-1",
+			wantGrants: []string{"client_credentials"},
+		},
+		{
+			name:      "valid cached token",
+			cached:    &oauth2Token{AccessToken: "cached", ExpiresAt: time.Now().Add(time.Hour)},
+			wantToken: "cached",
+		},
+		{
+			name:       "expired cached token with refresh token",
+			cached:     &oauth2Token{AccessToken: "cached", RefreshToken: "refresh", ExpiresAt: time.Now().Add(-time.Hour)},
+			wantToken:  "token-1",
+			wantGrants: []string{"refresh_token"},
+		},
+		{
+			name:       "expired cached token without refresh token",
+			cached:     &oauth2Token{AccessToken: "cached", ExpiresAt: time.Now().Add(-time.Hour)},
+			wantToken:  "token-1",
+			wantGrants: []string{"client_credentials"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			grants := []string{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if client, secret, _ := r.BasicAuth(); client != "client" || secret != "secret" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				grants = append(grants, r.FormValue("grant_type"))
+				if r.FormValue("grant_type") == "client_credentials" && r.FormValue("scope") != "broker.admin" {
+					t.Errorf("scope = %q, want broker.admin", r.FormValue("scope"))
+				}
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":3600}`, len(grants))
+			}))
+			defer server.Close()
+
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+			auth := &OAuth2ClientCredentials{TokenURL: server.URL, ClientID: "client", ClientSecret: "secret", Scopes: []string{"broker.admin"}, CacheDir: dir}
+			if test.cached != nil {
+				auth.writeCachedToken(test.cached)
+			}
+
+			token, err := auth.currentToken()
+			if err != nil {
+				t.Fatalf("currentToken() error: %s", err)
+			}
+			if token.AccessToken != test.wantToken {
+				t.Errorf("token = %q, want %q", token.AccessToken, test.wantToken)
+			}
+			if fmt.Sprint(grants) != fmt.Sprint(test.wantGrants) {
+				t.Errorf("grants = %v, want %v", grants, test.wantGrants)
+			}
+
+			// the token is kept in memory and on disk, so no more requests are sent
+			again := &OAuth2ClientCredentials{TokenURL: server.URL, ClientID: "client", ClientSecret: "secret", Scopes: []string{"broker.admin"}, CacheDir: dir}
+			for _, auth := range []*OAuth2ClientCredentials{auth, again} {
+				req, _ := http.NewRequest("GET", "http://broker/v2/catalog", nil)
+				if err = auth.Authenticate(req); err != nil {
+					t.Fatalf("Authenticate() error: %s", err)
+				}
+				if got := req.Header.Get("Authorization"); got != "Bearer "+test.wantToken {
+					t.Errorf("Authorization = %q, want %q", got, "Bearer "+test.wantToken)
+				}
+			}
+			if len(grants) != len(test.wantGrants) {
+				t.Errorf("%d token requests, want %d", len(grants), len(test.wantGrants))
+			}
+		})
+	}
+}
+
+func TestOAuth2TokenRequestErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"unauthorized", http.StatusUnauthorized, `{"error":"unauthorized"}`},
+		{"no access token", http.StatusOK, `{"token_type":"bearer"}`},
+		{"invalid JSON", http.StatusOK, `not json`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, test.body)
+			}))
+			defer server.Close()
+
+			auth := &OAuth2ClientCredentials{TokenURL: server.URL, ClientID: "client"}
+			if _, err := auth.currentToken(); err == nil {
+				t.Errorf("currentToken() succeeded, want an error")
+			}
+		})
+	}
+}
+
+// tempDir returns a new directory for the test to remove
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "eden-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
@@ -0,0 +1,417 @@
+package apiclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/errwrap"
+	"gopkg.in/yaml.v2"
+)
+
+// uuidPattern matches the generated IDs that are always normalized in cassettes
+const uuidPattern = `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`
+
+// placeholderPattern matches the placeholders that normalized IDs are replaced with
+var placeholderPattern = regexp.MustCompile(`\{\{id-(\d+)\}\}`)
+
+// Cassette is an http.RoundTripper that records each broker exchange to a
+// YAML file, or with Replay, serves recorded responses without any network.
+// Secret values are redacted, and generated IDs (UUIDs, plus values matching
+// Normalize patterns) are replaced with {{id-N}} placeholders. On replay a
+// placeholder matches any value, as long as it is the same value each time,
+// so commands that generate new IDs still find their recorded responses.
+// Recording appends to an existing file, so a sequence of eden commands can
+// be recorded into one cassette. Replay progress is kept in a state file next
+// to the cassette, so that a sequence of eden commands replays the exchanges
+// in the order they were recorded; it starts over once all have been replayed.
+type Cassette struct {
+	Path      string
+	Replay    bool
+	Normalize []string
+	Transport http.RoundTripper
+
+	mutex    sync.Mutex
+	loaded   bool
+	patterns *regexp.Regexp
+	file     cassetteFile
+	ids      map[string]int // recorded value -> placeholder number
+	bindings map[int]string // placeholder number -> replayed value
+	used     map[int]bool
+	cursor   int
+	checksum string
+}
+
+// cassetteState is the replay progress that is kept between eden commands
+type cassetteState struct {
+	Checksum string         `yaml:"checksum"`
+	Cursor   int            `yaml:"cursor"`
+	Used     []int          `yaml:"used,omitempty"`
+	Bindings map[int]string `yaml:"bindings,omitempty"`
+}
+
+type cassetteFile struct {
+	IDs          []string              `yaml:"ids,omitempty"`
+	Interactions []cassetteInteraction `yaml:"interactions"`
+}
+
+type cassetteInteraction struct {
+	Request  cassetteRequest  `yaml:"request"`
+	Response cassetteResponse `yaml:"response"`
+}
+
+type cassetteRequest struct {
+	Method string `yaml:"method"`
+	URL    string `yaml:"url"`
+	Body   string `yaml:"body,omitempty"`
+}
+
+type cassetteResponse struct {
+	Status      int    `yaml:"status"`
+	ContentType string `yaml:"content_type,omitempty"`
+	Body        string `yaml:"body,omitempty"`
+}
+
+// RoundTrip implements http.RoundTripper
+func (cassette *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	cassette.mutex.Lock()
+	err := cassette.load()
+	cassette.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var reqBody []byte
+	if req.Body != nil {
+		reqBody, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+	request := cassetteRequest{
+		Method: req.Method,
+		URL:    cassetteURL(req.URL),
+		Body:   redactValues(reqBody, req.Header.Get("Content-Type")),
+	}
+	if cassette.Replay {
+		return cassette.replay(req, request)
+	}
+
+	transport := cassette.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(resBody))
+
+	contentType := resp.Header.Get("Content-Type")
+	err = cassette.record(request, cassetteResponse{
+		Status:      resp.StatusCode,
+		ContentType: contentType,
+		Body:        redactValues(resBody, contentType),
+	})
+	return resp, err
+}
+
+// load reads the cassette file and compiles the normalization patterns, once
+func (cassette *Cassette) load() error {
+	if cassette.loaded {
+		return nil
+	}
+	patterns := []string{uuidPattern}
+	for _, pattern := range cassette.Normalize {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("cassette: invalid normalize pattern '%s': %s", pattern, err)
+		}
+		patterns = append(patterns, "(?:"+pattern+")")
+	}
+	cassette.patterns = regexp.MustCompile(strings.Join(patterns, "|"))
+
+	data, err := ioutil.ReadFile(cassette.Path)
+	if err != nil && !(os.IsNotExist(err) && !cassette.Replay) {
+		return errwrap.Wrapf("Could not read cassette: {{err}}", err)
+	}
+	if err = yaml.Unmarshal(data, &cassette.file); err != nil {
+		return errwrap.Wrapf("Could not parse cassette: {{err}}", err)
+	}
+	cassette.ids = map[string]int{}
+	for i, value := range cassette.file.IDs {
+		cassette.ids[value] = i + 1
+	}
+	cassette.bindings = map[int]string{}
+	cassette.used = map[int]bool{}
+	cassette.cursor = -1
+	cassette.loaded = true
+	if !cassette.Replay {
+		// the recorded exchanges change, so earlier replay progress is void
+		os.Remove(cassette.statePath())
+		return nil
+	}
+	cassette.checksum = fmt.Sprintf("%x", sha256.Sum256(data))
+	cassette.loadState()
+	return nil
+}
+
+func (cassette *Cassette) statePath() string {
+	return cassette.Path + ".state"
+}
+
+// loadState restores the replay progress of earlier commands, unless the
+// cassette has changed since or all of its exchanges have been replayed
+func (cassette *Cassette) loadState() {
+	data, err := ioutil.ReadFile(cassette.statePath())
+	if err != nil {
+		return
+	}
+	state := cassetteState{}
+	if yaml.Unmarshal(data, &state) != nil || state.Checksum != cassette.checksum {
+		return
+	}
+	if len(state.Used) >= len(cassette.file.Interactions) {
+		return
+	}
+	for _, i := range state.Used {
+		cassette.used[i] = true
+	}
+	for n, value := range state.Bindings {
+		cassette.bindings[n] = value
+	}
+	cassette.cursor = state.Cursor
+}
+
+// saveState writes the replay progress for the next command
+func (cassette *Cassette) saveState() error {
+	state := cassetteState{Checksum: cassette.checksum, Cursor: cassette.cursor, Bindings: cassette.bindings}
+	for i := range cassette.file.Interactions {
+		if cassette.used[i] {
+			state.Used = append(state.Used, i)
+		}
+	}
+	data, err := yaml.Marshal(state)
+	if err != nil {
+		return errwrap.Wrapf("Could not marshal cassette state: {{err}}", err)
+	}
+	tmp := cassette.statePath() + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errwrap.Wrapf("Could not write cassette state: {{err}}", err)
+	}
+	if err = os.Rename(tmp, cassette.statePath()); err != nil {
+		return errwrap.Wrapf("Could not write cassette state: {{err}}", err)
+	}
+	return nil
+}
+
+// record appends an exchange, with IDs normalized, and rewrites the cassette
+// file so it is complete even if eden exits abruptly
+func (cassette *Cassette) record(request cassetteRequest, response cassetteResponse) error {
+	cassette.mutex.Lock()
+	defer cassette.mutex.Unlock()
+
+	request.URL = cassette.normalize(request.URL)
+	request.Body = cassette.normalize(request.Body)
+	response.Body = cassette.normalize(response.Body)
+	cassette.file.Interactions = append(cassette.file.Interactions, cassetteInteraction{request, response})
+
+	data, err := yaml.Marshal(cassette.file)
+	if err != nil {
+		return errwrap.Wrapf("Could not marshal cassette: {{err}}", err)
+	}
+	if err = ioutil.WriteFile(cassette.Path, data, 0600); err != nil {
+		return errwrap.Wrapf("Could not write cassette: {{err}}", err)
+	}
+	return nil
+}
+
+// normalize replaces each generated ID with its placeholder
+func (cassette *Cassette) normalize(s string) string {
+	return cassette.patterns.ReplaceAllStringFunc(s, func(value string) string {
+		n, ok := cassette.ids[value]
+		if !ok {
+			cassette.file.IDs = append(cassette.file.IDs, value)
+			n = len(cassette.file.IDs)
+			cassette.ids[value] = n
+		}
+		return fmt.Sprintf("{{id-%d}}", n)
+	})
+}
+
+// replay responds with the first unused recorded exchange, after the last one
+// replayed, that matches the request; exchanges are reused if all are used
+func (cassette *Cassette) replay(req *http.Request, request cassetteRequest) (*http.Response, error) {
+	cassette.mutex.Lock()
+	defer cassette.mutex.Unlock()
+
+	incoming := request.Method + " " + request.URL + "\n" + request.Body
+	match, matchBindings := -1, map[int]string(nil)
+	for _, preferred := range []func(int) bool{
+		func(i int) bool { return i > cassette.cursor && !cassette.used[i] },
+		func(i int) bool { return !cassette.used[i] },
+		func(i int) bool { return true },
+	} {
+		for i, interaction := range cassette.file.Interactions {
+			if !preferred(i) {
+				continue
+			}
+			recorded := interaction.Request.Method + " " + interaction.Request.URL + "\n" + interaction.Request.Body
+			if bindings, ok := cassette.unify(recorded, incoming); ok {
+				match, matchBindings = i, bindings
+				if !cassette.used[i] {
+					break
+				}
+			}
+		}
+		if match >= 0 {
+			break
+		}
+	}
+	if match < 0 {
+		return nil, fmt.Errorf("cassette %s has no recorded response for %s %s", cassette.Path, request.Method, request.URL)
+	}
+	cassette.bindings = matchBindings
+	cassette.used[match] = true
+	cassette.cursor = match
+
+	response := cassette.file.Interactions[match].Response
+	body := placeholderPattern.ReplaceAllStringFunc(response.Body, func(placeholder string) string {
+		n, _ := strconv.Atoi(placeholderPattern.FindStringSubmatch(placeholder)[1])
+		if value, ok := cassette.bindings[n]; ok {
+			return value
+		}
+		// an ID the broker generated, rather than eden, so it is replayed as recorded
+		if n <= len(cassette.file.IDs) {
+			value := cassette.file.IDs[n-1]
+			cassette.bindings[n] = value
+			return value
+		}
+		return placeholder
+	})
+	if err := cassette.saveState(); err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	if response.ContentType != "" {
+		header.Set("Content-Type", response.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", response.Status, http.StatusText(response.Status)),
+		StatusCode:    response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// unify matches a recorded request against an incoming one, where each
+// placeholder must stand for the same value throughout the replay; it
+// returns the placeholder bindings that the match implies
+func (cassette *Cassette) unify(recorded, incoming string) (map[int]string, bool) {
+	recordedParts := placeholderPattern.Split(recorded, -1)
+	incomingParts := cassette.patterns.Split(incoming, -1)
+	if len(recordedParts) != len(incomingParts) {
+		return nil, false
+	}
+	for i := range recordedParts {
+		if recordedParts[i] != incomingParts[i] {
+			return nil, false
+		}
+	}
+
+	bindings := map[int]string{}
+	bound := map[string]int{}
+	for n, value := range cassette.bindings {
+		bindings[n] = value
+		bound[value] = n
+	}
+	values := cassette.patterns.FindAllString(incoming, -1)
+	for i, placeholder := range placeholderPattern.FindAllStringSubmatch(recorded, -1) {
+		n, _ := strconv.Atoi(placeholder[1])
+		value, ok := bindings[n]
+		if ok && value != values[i] {
+			return nil, false
+		}
+		if other, ok := bound[values[i]]; ok && other != n {
+			return nil, false
+		}
+		bindings[n] = values[i]
+		bound[values[i]] = n
+	}
+	return bindings, true
+}
+
+// cassetteURL returns the path and sorted query of a URL, with secret query
+// parameters redacted; the broker's host is not recorded
+func cassetteURL(u *url.URL) string {
+	query := u.Query()
+	for key := range query {
+		if sensitiveKey(key) {
+			query.Set(key, redacted)
+		}
+	}
+	if len(query) == 0 {
+		return u.Path
+	}
+	return u.Path + "?" + query.Encode()
+}
+
+// redactValues returns a JSON or form encoded body with secret values
+// redacted; unlike redactBody, JSON objects and arrays under secret keys keep
+// their structure, so replayed credentials still have the expected shape
+func redactValues(body []byte, contentType string) string {
+	if len(body) == 0 || strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		return redactBody(body, contentType)
+	}
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return strings.TrimSpace(string(body))
+	}
+	bytes, err := json.Marshal(redactLeaves(data, false))
+	if err != nil {
+		return strings.TrimSpace(string(body))
+	}
+	return string(bytes)
+}
+
+func redactLeaves(data interface{}, secret bool) interface{} {
+	switch value := data.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			result[key] = redactLeaves(item, secret || sensitiveKey(key))
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, item := range value {
+			result[i] = redactLeaves(item, secret)
+		}
+		return result
+	default:
+		if secret && data != nil {
+			return redacted
+		}
+		return data
+	}
+}
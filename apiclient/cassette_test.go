@@ -0,0 +1,192 @@
+package apiclient
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	recordedInstance = "11111111-1111-1111-1111-111111111111"
+	recordedBinding  = "22222222-2222-2222-2222-222222222222"
+	replayedInstance = "33333333-3333-3333-3333-333333333333"
+	replayedBinding  = "44444444-4444-4444-4444-444444444444"
+)
+
+// sendThroughCassette sends a request through the cassette and returns the
+// status and body of the response
+func sendThroughCassette(t *testing.T, cassette *Cassette, method, path, body string) (int, string, error) {
+	req, err := http.NewRequest(method, "http://broker"+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := cassette.RoundTrip(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	resBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(resBody), nil
+}
+
+// recordCassette records a provision and a bind against a fake broker
+func recordCassette(t *testing.T, path string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(r.URL.Path, "/service_bindings/") {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"credentials":{"username":"admin","password":"s3cret","hosts":["10.0.0.1"]}}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"dashboard_url":"http://dashboard/` + recordedInstance + `"}`))
+	}))
+	defer server.Close()
+
+	cassette := &Cassette{Path: path, Transport: redirectTransport{target: server.URL, transport: http.DefaultTransport}}
+	for _, request := range []struct{ method, path, body string }{
+		{"PUT", "/v2/service_instances/" + recordedInstance, `{"plan_id":"small"}`},
+		{"PUT", "/v2/service_instances/" + recordedInstance + "/service_bindings/" + recordedBinding, `{"plan_id":"small"}`},
+	} {
+		if _, _, err := sendThroughCassette(t, cassette, request.method, request.path, request.body); err != nil {
+			t.Fatalf("recording %s %s: %s", request.method, request.path, err)
+		}
+	}
+}
+
+// redirectTransport sends every request to the server at target
+type redirectTransport struct {
+	target    string
+	transport http.RoundTripper
+}
+
+func (r redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	redirected, err := http.NewRequest(req.Method, r.target+req.URL.RequestURI(), req.Body)
+	if err != nil {
+		return nil, err
+	}
+	redirected.Header = req.Header
+	return r.transport.RoundTrip(redirected)
+}
+
+func TestCassetteRecordRedactsAndNormalizes(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cassette.yml")
+	recordCassette(t, path)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		text    string
+		present bool
+	}{
+		{"instance ID placeholder", "/v2/service_instances/{{id-1}}", true},
+		{"binding ID placeholder", "/service_bindings/{{id-2}}", true},
+		{"password redacted", "s3cret", false},
+		{"username redacted", "admin", false},
+		{"credentials keep their shape", `"hosts":["[REDACTED]"]`, true},
+		{"non-secret value kept", "http://dashboard/{{id-1}}", true},
+		{"broker host not recorded", "127.0.0.1", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if present := strings.Contains(string(data), test.text); present != test.present {
+				t.Errorf("cassette contains %q = %v, want %v:\n%s", test.text, present, test.present, data)
+			}
+		})
+	}
+}
+
+func TestCassetteReplay(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests []struct{ method, path string }
+		wantErr  bool
+		wantBody string
+	}{
+		{
+			name: "recorded IDs",
+			requests: []struct{ method, path string }{
+				{"PUT", "/v2/service_instances/" + recordedInstance},
+			},
+			wantBody: recordedInstance,
+		},
+		{
+			name: "new IDs in the same places",
+			requests: []struct{ method, path string }{
+				{"PUT", "/v2/service_instances/" + replayedInstance},
+				{"PUT", "/v2/service_instances/" + replayedInstance + "/service_bindings/" + replayedBinding},
+			},
+			wantBody: `"hosts":["[REDACTED]"]`,
+		},
+		{
+			name: "one placeholder bound to two IDs",
+			requests: []struct{ method, path string }{
+				{"PUT", "/v2/service_instances/" + replayedInstance},
+				{"PUT", "/v2/service_instances/" + replayedBinding + "/service_bindings/" + replayedBinding},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unrecorded path",
+			requests: []struct{ method, path string }{
+				{"DELETE", "/v2/service_instances/" + replayedInstance},
+			},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "cassette.yml")
+			recordCassette(t, path)
+
+			cassette := &Cassette{Path: path, Replay: true}
+			var body string
+			var err error
+			for _, request := range test.requests {
+				if _, body, err = sendThroughCassette(t, cassette, request.method, request.path, `{"plan_id":"small"}`); err != nil {
+					break
+				}
+			}
+			if (err != nil) != test.wantErr {
+				t.Fatalf("replay error = %v, want error %v", err, test.wantErr)
+			}
+			if !test.wantErr && !strings.Contains(body, test.wantBody) {
+				t.Errorf("replayed body %s does not contain %s", body, test.wantBody)
+			}
+		})
+	}
+}
+
+func TestCassetteReplayKeepsIDsAcrossCommands(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cassette.yml")
+	recordCassette(t, path)
+
+	// each eden command is a new cassette, which continues from the saved state
+	first := &Cassette{Path: path, Replay: true}
+	if _, body, err := sendThroughCassette(t, first, "PUT", "/v2/service_instances/"+replayedInstance, `{"plan_id":"small"}`); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(body, replayedInstance) {
+		t.Errorf("replayed body %s does not contain the new instance ID", body)
+	}
+	second := &Cassette{Path: path, Replay: true}
+	if _, _, err := sendThroughCassette(t, second, "PUT", "/v2/service_instances/"+recordedInstance+"/service_bindings/"+replayedBinding, `{"plan_id":"small"}`); err == nil {
+		t.Errorf("replay matched another instance ID than the one bound by the earlier command")
+	}
+}
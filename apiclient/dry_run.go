@@ -0,0 +1,94 @@
+package apiclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// ErrDryRun is returned, wrapped, for each request that DryRun printed instead of sending
+var ErrDryRun = errors.New("dry run: request not sent")
+
+// IsDryRun returns true if err is, or wraps, ErrDryRun
+func IsDryRun(err error) bool {
+	return err != nil && strings.Contains(err.Error(), ErrDryRun.Error())
+}
+
+// DryRun is an http.RoundTripper that prints each request that would change
+// anything on the broker, as HTTP or as an equivalent curl command, instead
+// of sending it. GET requests, e.g. for the catalog, are still sent so that
+// services, plans and instances resolve as usual. Secret headers are redacted.
+type DryRun struct {
+	Transport http.RoundTripper
+	Output    io.Writer
+	Curl      bool
+}
+
+// RoundTrip implements http.RoundTripper
+func (dryRun *DryRun) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == "GET" || req.Method == "HEAD" {
+		transport := dryRun.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		return transport.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	body = bytes.TrimSpace(body)
+	if dryRun.Curl {
+		dryRun.printCurl(req, body)
+	} else {
+		dryRun.printHTTP(req, body)
+	}
+	return nil, ErrDryRun
+}
+
+func (dryRun *DryRun) printHTTP(req *http.Request, body []byte) {
+	out := &bytes.Buffer{}
+	defer func() { dryRun.Output.Write(out.Bytes()) }()
+	fmt.Fprintf(out, "%s %s\n", req.Method, req.URL)
+	for _, header := range redactHeaders(req.Header) {
+		fmt.Fprintf(out, "%s: %s\n", header.Name, header.Value)
+	}
+	if len(body) > 0 {
+		pretty := &bytes.Buffer{}
+		if json.Indent(pretty, body, "", "  ") == nil {
+			body = pretty.Bytes()
+		}
+		fmt.Fprintf(out, "\n%s\n", body)
+	}
+	fmt.Fprintln(out)
+}
+
+func (dryRun *DryRun) printCurl(req *http.Request, body []byte) {
+	out := &bytes.Buffer{}
+	defer func() { dryRun.Output.Write(out.Bytes()) }()
+	fmt.Fprintf(out, "curl -X %s %s", req.Method, ShellQuote(req.URL.String()))
+	for _, header := range redactHeaders(req.Header) {
+		fmt.Fprintf(out, " \\\n  -H %s", ShellQuote(header.Name+": "+header.Value))
+	}
+	if len(body) > 0 {
+		fmt.Fprintf(out, " \\\n  -d %s", ShellQuote(string(body)))
+	}
+	fmt.Fprint(out, "\n\n")
+}
+
+// ShellQuote quotes s as a single argument for POSIX shells, e.g. in curl
+// commands or in credentials exported as shell variables
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
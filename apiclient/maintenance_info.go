@@ -0,0 +1,112 @@
+package apiclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// MaintenanceInfo identifies the version of the software behind a plan (OSB 2.15)
+type MaintenanceInfo struct {
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// PlanMaintenanceInfo returns the maintenance_info of a plan in the catalog,
+// or nil if the plan has none or the broker does not support it
+func (broker *OpenServiceBroker) PlanMaintenanceInfo(planID string) (*MaintenanceInfo, error) {
+	if !broker.Supports(FeatureMaintenanceInfo) {
+		return nil, nil
+	}
+	if _, err := broker.Catalog(); err != nil {
+		return nil, err
+	}
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	return broker.maintenanceInfo[planID], nil
+}
+
+// Upgrade updates a service instance to the maintenance_info of its plan
+func (broker *OpenServiceBroker) Upgrade(serviceID, planID, instanceID string, maintenanceInfo, previous *MaintenanceInfo) (updateResp *brokerapi.UpdateResponse, isAsync bool, err error) {
+	if !broker.Supports(FeatureMaintenanceInfo) {
+		return nil, false, broker.unsupported(FeatureMaintenanceInfo)
+	}
+	url := fmt.Sprintf("%s/v2/service_instances/%s?accepts_incomplete=true", broker.url, instanceID)
+	details := map[string]interface{}{
+		"service_id":       serviceID,
+		"plan_id":          planID,
+		"maintenance_info": maintenanceInfo,
+	}
+	previousValues := map[string]interface{}{
+		"service_id": serviceID,
+		"plan_id":    planID,
+	}
+	if previous != nil {
+		previousValues["maintenance_info"] = previous
+	}
+	details["previous_values"] = previousValues
+
+	resp, resBody, err := broker.doRequest("PATCH", url, details)
+	if err != nil {
+		return nil, false, err
+	}
+	if err = responseError(resp, resBody); err != nil {
+		return nil, false, err
+	}
+	isAsync = resp.StatusCode == http.StatusAccepted
+
+	updateResp = &brokerapi.UpdateResponse{}
+	json.Unmarshal(resBody, updateResp)
+	return
+}
+
+// NewerMaintenanceVersion returns true if available is a newer maintenance_info
+// version than current. Versions are compared as semantic versions; if either
+// is not one, any difference counts as newer, as platforms can only upgrade
+// to the version in the catalog.
+func NewerMaintenanceVersion(current, available string) bool {
+	if available == "" || available == current {
+		return false
+	}
+	if current == "" {
+		return true
+	}
+	currentParts, ok := parseSemver(current)
+	if !ok {
+		return true
+	}
+	availableParts, ok := parseSemver(available)
+	if !ok {
+		return true
+	}
+	for i := range currentParts {
+		if availableParts[i] != currentParts[i] {
+			return availableParts[i] > currentParts[i]
+		}
+	}
+	// same major.minor.patch; a release is newer than its pre-releases
+	return strings.Contains(current, "-") && !strings.Contains(available, "-")
+}
+
+// parseSemver returns the major, minor and patch numbers of a semantic version
+func parseSemver(version string) ([3]int, bool) {
+	var parts [3]int
+	version = strings.TrimPrefix(version, "v")
+	version = strings.SplitN(strings.SplitN(version, "+", 2)[0], "-", 2)[0]
+	fields := strings.Split(version, ".")
+	if len(fields) != 3 {
+		return parts, false
+	}
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return parts, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
@@ -3,9 +3,15 @@ package apiclient
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/pivotal-cf/brokerapi"
@@ -13,111 +19,415 @@ import (
 
 // OpenServiceBroker is the client struct for connecting to remote Open Service Broker API
 type OpenServiceBroker struct {
-	url        string
-	username   string
-	password   string
-	catalog    *brokerapi.CatalogResponse
-	apiVersion string
+	url    string
+	auth   Authenticator
+	client *http.Client
+
+	onNegotiate  func(version string)
+	pollInterval time.Duration
+
+	// the client is shared by concurrent operations, e.g. of apply and bench
+	mutex           sync.Mutex
+	negotiateMutex  sync.Mutex
+	apiVersion      string
+	catalog         *brokerapi.CatalogResponse
+	maintenanceInfo map[string]*MaintenanceInfo
+	retrievable     map[string]retrievable
 }
 
-// NewOpenServiceBroker constructs OpenServiceBroker
+// retrievable are the catalog flags of a service for its GET endpoints
+type retrievable struct {
+	Instances bool `json:"instances_retrievable"`
+	Bindings  bool `json:"bindings_retrievable"`
+}
+
+// DefaultTimeout bounds each request to a broker, as platforms do, so that a
+// broker that never responds does not hang eden
+const DefaultTimeout = 60 * time.Second
+
+// NewOpenServiceBroker constructs OpenServiceBroker using basic auth
 func NewOpenServiceBroker(url, client, clientSecret, apiVersion string) *OpenServiceBroker {
+	return NewOpenServiceBrokerWithAuth(url, BasicAuth{Username: client, Password: clientSecret}, apiVersion)
+}
+
+// NewOpenServiceBrokerWithAuth constructs OpenServiceBroker using any Authenticator
+func NewOpenServiceBrokerWithAuth(url string, auth Authenticator, apiVersion string) *OpenServiceBroker {
 	return &OpenServiceBroker{
-		url:        url,
-		username:   client,
-		password:   clientSecret,
+		url:        strings.TrimSuffix(url, "/"),
+		auth:       auth,
 		apiVersion: apiVersion,
+		client:     &http.Client{Timeout: DefaultTimeout},
+
+		pollInterval: 5 * time.Second,
 	}
 }
 
-// Catalog fetches the available service catalog from remote broker
-func (broker *OpenServiceBroker) Catalog() (catalogResp *brokerapi.CatalogResponse, err error) {
-	if broker.catalog == nil {
-		url := fmt.Sprintf("%s/v2/catalog", broker.url)
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return nil, errwrap.Wrapf("Cannot construct HTTP request: {{err}}", err)
-		}
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("X-Broker-Api-Version", broker.apiVersion)
-		req.SetBasicAuth(broker.username, broker.password)
+// SetPollInterval sets the time between last_operation requests for async bindings
+func (broker *OpenServiceBroker) SetPollInterval(interval time.Duration) {
+	broker.pollInterval = interval
+}
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			return nil, errwrap.Wrapf("Failed doing HTTP request: {{err}}", err)
+// SetTransport replaces the HTTP transport used for all broker requests, e.g. with a Tracer
+func (broker *OpenServiceBroker) SetTransport(transport http.RoundTripper) {
+	broker.client.Transport = transport
+}
+
+// doRequest sends an authenticated request to the broker and returns the
+// response along with its fully read body
+func (broker *OpenServiceBroker) doRequest(method, url string, body interface{}) (resp *http.Response, resBody []byte, err error) {
+	var reqBody io.Reader
+	if body != nil {
+		buffer := &bytes.Buffer{}
+		if err = json.NewEncoder(buffer).Encode(body); err != nil {
+			return nil, nil, errwrap.Wrapf("Cannot encode request body: {{err}}", err)
 		}
-		defer resp.Body.Close()
+		reqBody = buffer
+	}
+	return broker.send(method, url, reqBody, nil)
+}
 
-		resBody, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return nil, errwrap.Wrapf("Failed reading HTTP response body: {{err}}", err)
+// Request sends an authenticated request with a raw body to a path of the
+// broker, e.g. for endpoints this client does not support; headers override
+// the defaults
+func (broker *OpenServiceBroker) Request(method, path string, body io.Reader, headers http.Header) (resp *http.Response, resBody []byte, err error) {
+	return broker.send(method, broker.url+"/"+strings.TrimPrefix(path, "/"), body, headers)
+}
+
+// send sends a request; if the broker rejects the API version with 412
+// Precondition Failed and renegotiation is enabled with OnNegotiate, it
+// negotiates a version and sends the request again
+func (broker *OpenServiceBroker) send(method, url string, reqBody io.Reader, headers http.Header) (resp *http.Response, resBody []byte, err error) {
+	var body []byte
+	if reqBody != nil {
+		if body, err = ioutil.ReadAll(reqBody); err != nil {
+			return nil, nil, errwrap.Wrapf("Cannot read request body: {{err}}", err)
 		}
+	}
+	rejected := broker.APIVersion()
+	resp, resBody, err = broker.sendOnce(method, url, rejected, body, headers)
+	if err != nil || resp.StatusCode != http.StatusPreconditionFailed || broker.onNegotiate == nil {
+		return resp, resBody, err
+	}
+	// concurrent requests rejected with the same version negotiate only once
+	broker.negotiateMutex.Lock()
+	if broker.APIVersion() == rejected {
+		broker.NegotiateAPIVersion()
+	}
+	broker.negotiateMutex.Unlock()
+	version := broker.APIVersion()
+	if version == rejected {
+		return resp, resBody, nil
+	}
+	return broker.sendOnce(method, url, version, body, headers)
+}
 
-		broker.catalog = &brokerapi.CatalogResponse{}
-		err = json.Unmarshal(resBody, broker.catalog)
-		if err != nil {
-			return nil, errwrap.Wrapf("Failed unmarshalling catalog response: {{err}}", err)
+func (broker *OpenServiceBroker) sendOnce(method, url, version string, body []byte, headers http.Header) (resp *http.Response, resBody []byte, err error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return nil, nil, errwrap.Wrapf("Cannot construct HTTP request: {{err}}", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Broker-Api-Version", version)
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+	if broker.auth != nil {
+		if err = broker.auth.Authenticate(req); err != nil {
+			return nil, nil, errwrap.Wrapf("Failed to authenticate HTTP request: {{err}}", err)
 		}
 	}
-	return broker.catalog, nil
+
+	resp, err = broker.client.Do(req)
+	if err != nil {
+		return nil, nil, &RequestError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	resBody, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, errwrap.Wrapf("Failed reading HTTP response body: {{err}}", err)
+	}
+	return resp, resBody, nil
 }
 
-// Provision attempts to provision a new service instance
-func (broker *OpenServiceBroker) Provision(serviceID, planID, instanceID string, parameters json.RawMessage) (provisioningResp *brokerapi.ProvisioningResponse, isAsync bool, err error) {
-	url := fmt.Sprintf("%s/v2/service_instances/%s?accepts_incomplete=true", broker.url, instanceID)
-	details := brokerapi.ProvisionDetails{
-		ServiceID:        serviceID,
-		PlanID:           planID,
-		OrganizationGUID: "eden-unknown-guid",
-		SpaceGUID:        "eden-unknown-space",
-		RawParameters:    parameters,
+// RequestError is returned when a request could not be sent to the broker, or
+// its response not received
+type RequestError struct {
+	Err error
+}
+
+func (err *RequestError) Error() string {
+	return fmt.Sprintf("Failed doing HTTP request: %s", err.Err)
+}
+
+// Unwrap returns the error of the HTTP client
+func (err *RequestError) Unwrap() error {
+	return err.Err
+}
+
+// APIError is returned when the broker responds with an error status
+type APIError struct {
+	StatusCode int
+	Response   brokerapi.ErrorResponse
+}
+
+func (err *APIError) Error() string {
+	return fmt.Sprintf("API request error %d: %v", err.StatusCode, &err.Response)
+}
+
+// responseError converts a broker error response into an *APIError, or nil for 2xx/3xx
+func responseError(resp *http.Response, resBody []byte) error {
+	if resp.StatusCode < 400 {
+		return nil
 	}
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	json.Unmarshal(resBody, &apiErr.Response)
+	return apiErr
+}
 
-	buffer := &bytes.Buffer{}
-	if err = json.NewEncoder(buffer).Encode(details); err != nil {
-		return nil, false, errwrap.Wrapf("Cannot encode provisioning details: {{err}}", err)
+// Catalog fetches the available service catalog from remote broker, once
+func (broker *OpenServiceBroker) Catalog() (catalogResp *brokerapi.CatalogResponse, err error) {
+	broker.mutex.Lock()
+	catalog := broker.catalog
+	broker.mutex.Unlock()
+	if catalog != nil {
+		return catalog, nil
+	}
+	if catalog, err = broker.FetchCatalog(); err != nil {
+		return nil, err
 	}
-	req, err := http.NewRequest("PUT", url, buffer)
+	broker.setCatalog(catalog)
+	return catalog, nil
+}
+
+func (broker *OpenServiceBroker) setCatalog(catalog *brokerapi.CatalogResponse) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	broker.catalog = catalog
+}
+
+// FetchCatalog fetches the service catalog from remote broker, bypassing the cache
+func (broker *OpenServiceBroker) FetchCatalog() (catalogResp *brokerapi.CatalogResponse, err error) {
+	url := fmt.Sprintf("%s/v2/catalog", broker.url)
+	resp, resBody, err := broker.doRequest("GET", url, nil)
 	if err != nil {
-		return nil, false, errwrap.Wrapf("Cannot construct HTTP request: {{err}}", err)
+		return nil, err
+	}
+	if err = responseError(resp, resBody); err != nil {
+		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Broker-Api-Version", broker.apiVersion)
-	req.SetBasicAuth(broker.username, broker.password)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	catalogResp, err = broker.decodeCatalog(resBody)
 	if err != nil {
-		return nil, false, errwrap.Wrapf("Failed doing HTTP request: {{err}}", err)
+		return nil, errwrap.Wrapf("Failed unmarshalling catalog response: {{err}}", err)
 	}
-	defer resp.Body.Close()
+	return catalogResp, nil
+}
+
+// decodeCatalog decodes a catalog response, keeping the fields of services
+// and plans that brokerapi has none for: the maintenance_info of each plan,
+// and whether the instances and bindings of each service are retrievable
+func (broker *OpenServiceBroker) decodeCatalog(resBody []byte) (*brokerapi.CatalogResponse, error) {
+	catalog := &brokerapi.CatalogResponse{}
+	if err := json.Unmarshal(resBody, catalog); err != nil {
+		return nil, err
+	}
+	var extensions struct {
+		Services []struct {
+			ID string `json:"id"`
+			retrievable
+			Plans []struct {
+				ID              string           `json:"id"`
+				MaintenanceInfo *MaintenanceInfo `json:"maintenance_info"`
+			} `json:"plans"`
+		} `json:"services"`
+	}
+	json.Unmarshal(resBody, &extensions)
+	maintenanceInfo := map[string]*MaintenanceInfo{}
+	retrievableFlags := map[string]retrievable{}
+	for _, service := range extensions.Services {
+		retrievableFlags[service.ID] = service.retrievable
+		for _, plan := range service.Plans {
+			if plan.MaintenanceInfo != nil && plan.MaintenanceInfo.Version != "" {
+				maintenanceInfo[plan.ID] = plan.MaintenanceInfo
+			}
+		}
+	}
+	broker.mutex.Lock()
+	broker.maintenanceInfo = maintenanceInfo
+	broker.retrievable = retrievableFlags
+	broker.mutex.Unlock()
+	return catalog, nil
+}
+
+// InstancesRetrievable returns true if the catalog sets instances_retrievable
+// for the service; otherwise brokers need not implement GET for its instances
+func (broker *OpenServiceBroker) InstancesRetrievable(serviceID string) (bool, error) {
+	flags, err := broker.retrievableFlags(serviceID)
+	return flags.Instances, err
+}
+
+// BindingsRetrievable returns true if the catalog sets bindings_retrievable
+// for the service; otherwise brokers need not implement GET for its bindings
+func (broker *OpenServiceBroker) BindingsRetrievable(serviceID string) (bool, error) {
+	flags, err := broker.retrievableFlags(serviceID)
+	return flags.Bindings, err
+}
 
-	resBody, err := ioutil.ReadAll(resp.Body)
+func (broker *OpenServiceBroker) retrievableFlags(serviceID string) (retrievable, error) {
+	if _, err := broker.Catalog(); err != nil {
+		return retrievable{}, err
+	}
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	return broker.retrievable[serviceID], nil
+}
+
+// Provision attempts to provision a new service instance, at the
+// maintenance_info version of its plan, if it has one
+func (broker *OpenServiceBroker) Provision(serviceID, planID, instanceID string, parameters json.RawMessage) (provisioningResp *brokerapi.ProvisioningResponse, isAsync bool, err error) {
+	url := fmt.Sprintf("%s/v2/service_instances/%s?accepts_incomplete=true", broker.url, instanceID)
+	maintenanceInfo, err := broker.PlanMaintenanceInfo(planID)
+	if err != nil {
+		return nil, false, err
+	}
+	details := struct {
+		brokerapi.ProvisionDetails
+		MaintenanceInfo *MaintenanceInfo `json:"maintenance_info,omitempty"`
+	}{
+		ProvisionDetails: brokerapi.ProvisionDetails{
+			ServiceID:        serviceID,
+			PlanID:           planID,
+			OrganizationGUID: "eden-unknown-guid",
+			SpaceGUID:        "eden-unknown-space",
+			RawParameters:    parameters,
+		},
+		MaintenanceInfo: maintenanceInfo,
+	}
+
+	resp, resBody, err := broker.doRequest("PUT", url, details)
 	if err != nil {
-		return nil, false, errwrap.Wrapf("Failed reading HTTP response body: {{err}}", err)
+		return nil, false, err
+	}
+	if err = responseError(resp, resBody); err != nil {
+		return nil, false, err
 	}
 	provisioningResp = &brokerapi.ProvisioningResponse{}
 	err = json.Unmarshal(resBody, provisioningResp)
 	if err != nil {
 		return nil, false, errwrap.Wrapf("Failed unmarshalling provisioning response: {{err}}", err)
 	}
-	if resp.StatusCode == http.StatusAccepted {
-		isAsync = true
-	} else if resp.StatusCode == http.StatusCreated || resp.StatusCode == http.StatusOK {
-		isAsync = false
+	isAsync = resp.StatusCode == http.StatusAccepted
+	return
+}
+
+// Update changes the plan and/or parameters of a service instance
+func (broker *OpenServiceBroker) Update(serviceID, planID, previousPlanID, instanceID string, parameters json.RawMessage) (updateResp *brokerapi.UpdateResponse, isAsync bool, err error) {
+	url := fmt.Sprintf("%s/v2/service_instances/%s?accepts_incomplete=true", broker.url, instanceID)
+	details := brokerapi.UpdateDetails{
+		ServiceID:     serviceID,
+		PlanID:        planID,
+		RawParameters: parameters,
+		PreviousValues: brokerapi.PreviousValues{
+			ServiceID: serviceID,
+			PlanID:    previousPlanID,
+			OrgID:     "eden-unknown-guid",
+			SpaceID:   "eden-unknown-space",
+		},
 	}
-	if resp.StatusCode >= 400 {
-		errorResp := &brokerapi.ErrorResponse{}
-		json.Unmarshal(resBody, errorResp)
-		return nil, false, fmt.Errorf("API request error %d: %v", resp.StatusCode, errorResp)
+
+	resp, resBody, err := broker.doRequest("PATCH", url, details)
+	if err != nil {
+		return nil, false, err
 	}
+	if err = responseError(resp, resBody); err != nil {
+		return nil, false, err
+	}
+	isAsync = resp.StatusCode == http.StatusAccepted
+
+	updateResp = &brokerapi.UpdateResponse{}
+	json.Unmarshal(resBody, updateResp)
 	return
 }
 
-// Bind requests new set of credentials to access service instance
-func (broker *OpenServiceBroker) Bind(serviceID, planID, instanceID, bindingID string,  parameters json.RawMessage) (binding *brokerapi.Binding, err error) {
+// InstanceResponse is the response to fetching a service instance (OSB 2.14)
+type InstanceResponse struct {
+	ServiceID    string          `json:"service_id"`
+	PlanID       string          `json:"plan_id"`
+	DashboardURL string          `json:"dashboard_url,omitempty"`
+	Parameters   json.RawMessage `json:"parameters,omitempty"`
+}
+
+// GetInstance fetches a service instance; found is false if the broker does
+// not have it. The catalog must set instances_retrievable for the service, as
+// brokers otherwise answer 404 Not Found for any instance.
+func (broker *OpenServiceBroker) GetInstance(serviceID, instanceID string) (instance *InstanceResponse, found bool, err error) {
+	if !broker.Supports(FeatureGetEndpoints) {
+		return nil, false, broker.unsupported(FeatureGetEndpoints)
+	}
+	retrievable, err := broker.InstancesRetrievable(serviceID)
+	if err != nil {
+		return nil, false, err
+	}
+	if !retrievable {
+		return nil, false, fmt.Errorf("the catalog does not set instances_retrievable for service %s", serviceID)
+	}
+	url := fmt.Sprintf("%s/v2/service_instances/%s", broker.url, instanceID)
+
+	resp, resBody, err := broker.doRequest("GET", url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return nil, false, nil
+	}
+	if err = responseError(resp, resBody); err != nil {
+		return nil, false, err
+	}
+
+	instance = &InstanceResponse{}
+	if err = json.Unmarshal(resBody, instance); err != nil {
+		return nil, false, errwrap.Wrapf("Failed unmarshalling instance response: {{err}}", err)
+	}
+	return instance, true, nil
+}
+
+// BindingResponse is the full response to a bind request, including the
+// endpoints and metadata fields added in later OSB API versions
+type BindingResponse struct {
+	Credentials     interface{}             `json:"credentials"`
+	SyslogDrainURL  string                  `json:"syslog_drain_url,omitempty"`
+	RouteServiceURL string                  `json:"route_service_url,omitempty"`
+	VolumeMounts    []brokerapi.VolumeMount `json:"volume_mounts,omitempty"`
+	Endpoints       []BindingEndpoint       `json:"endpoints,omitempty"`
+	Metadata        *BindingMetadata        `json:"metadata,omitempty"`
+}
+
+// BindingEndpoint is a network endpoint that an application uses with a binding
+type BindingEndpoint struct {
+	Host     string   `json:"host"`
+	Ports    []string `json:"ports"`
+	Protocol string   `json:"protocol,omitempty"`
+}
+
+// BindingMetadata describes the lifecycle of a binding (OSB 2.16)
+type BindingMetadata struct {
+	ExpiresAt   string `json:"expires_at,omitempty"`
+	RenewBefore string `json:"renew_before,omitempty"`
+}
+
+// Bind requests new set of credentials to access service instance. If the
+// broker binds asynchronously (OSB 2.14), Bind waits for the binding to be
+// created and then fetches it
+func (broker *OpenServiceBroker) Bind(serviceID, planID, instanceID, bindingID string, parameters json.RawMessage) (binding *BindingResponse, err error) {
 	url := fmt.Sprintf("%s/v2/service_instances/%s/service_bindings/%s", broker.url, instanceID, bindingID)
+	if broker.Supports(FeatureAsyncBindings) {
+		url += "?accepts_incomplete=true"
+	}
 	details := brokerapi.BindDetails{
 		ServiceID:     serviceID,
 		PlanID:        planID,
@@ -125,73 +435,155 @@ func (broker *OpenServiceBroker) Bind(serviceID, planID, instanceID, bindingID s
 		RawParameters: parameters,
 	}
 
-	buffer := &bytes.Buffer{}
-	if err = json.NewEncoder(buffer).Encode(details); err != nil {
-		return nil, errwrap.Wrapf("Cannot encode binding details: {{err}}", err)
-	}
-	req, err := http.NewRequest("PUT", url, buffer)
+	resp, resBody, err := broker.doRequest("PUT", url, details)
 	if err != nil {
-		return nil, errwrap.Wrapf("Cannot construct HTTP request: {{err}}", err)
+		return nil, err
+	}
+	if err = responseError(resp, resBody); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusAccepted {
+		if err = broker.waitForBinding(serviceID, planID, instanceID, bindingID, resBody); err != nil {
+			return nil, err
+		}
+		binding, found, err := broker.GetBinding(serviceID, instanceID, bindingID)
+		if err == nil && !found {
+			err = fmt.Errorf("binding %s was not found after it was created", bindingID)
+		}
+		return binding, err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Broker-Api-Version", broker.apiVersion)
-	req.SetBasicAuth(broker.username, broker.password)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	binding = &BindingResponse{}
+	err = json.Unmarshal(resBody, binding)
 	if err != nil {
-		return nil, errwrap.Wrapf("Failed doing HTTP request: {{err}}", err)
+		return nil, errwrap.Wrapf("Failed unmarshalling binding response: {{err}}", err)
 	}
-	defer resp.Body.Close()
+	return
+}
 
-	resBody, err := ioutil.ReadAll(resp.Body)
+// GetBinding fetches a service binding; found is false if the broker does not
+// have it. The catalog must set bindings_retrievable for the service, as
+// brokers otherwise answer 404 Not Found for any binding.
+func (broker *OpenServiceBroker) GetBinding(serviceID, instanceID, bindingID string) (binding *BindingResponse, found bool, err error) {
+	if !broker.Supports(FeatureGetEndpoints) {
+		return nil, false, broker.unsupported(FeatureGetEndpoints)
+	}
+	retrievable, err := broker.BindingsRetrievable(serviceID)
 	if err != nil {
-		return nil, errwrap.Wrapf("Failed reading HTTP response body: {{err}}", err)
+		return nil, false, err
 	}
-	if resp.StatusCode >= 400 {
-		errorResp := &brokerapi.ErrorResponse{}
-		json.Unmarshal(resBody, errorResp)
-		return nil, fmt.Errorf("API request error %d: %v", resp.StatusCode, errorResp)
+	if !retrievable {
+		return nil, false, fmt.Errorf("the catalog does not set bindings_retrievable for service %s", serviceID)
 	}
+	url := fmt.Sprintf("%s/v2/service_instances/%s/service_bindings/%s", broker.url, instanceID, bindingID)
 
-	binding = &brokerapi.Binding{}
-	err = json.Unmarshal(resBody, binding)
+	resp, resBody, err := broker.doRequest("GET", url, nil)
 	if err != nil {
-		return nil, errwrap.Wrapf("Failed unmarshalling binding response: {{err}}", err)
+		return nil, false, err
 	}
-	return
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return nil, false, nil
+	}
+	if err = responseError(resp, resBody); err != nil {
+		return nil, false, err
+	}
+
+	binding = &BindingResponse{}
+	if err = json.Unmarshal(resBody, binding); err != nil {
+		return nil, false, errwrap.Wrapf("Failed unmarshalling binding response: {{err}}", err)
+	}
+	return binding, true, nil
 }
 
-// Unbind destroys a set of credentials to access the service instance
+// Unbind destroys a set of credentials to access the service instance. If the
+// broker unbinds asynchronously (OSB 2.14), Unbind waits for it to finish
 func (broker *OpenServiceBroker) Unbind(serviceID, planID, instanceID, bindingID string) (err error) {
 	url := fmt.Sprintf("%s/v2/service_instances/%s/service_bindings/%s?service_id=%s&plan_id=%s",
 		broker.url, instanceID, bindingID, serviceID, planID)
+	if broker.Supports(FeatureAsyncBindings) {
+		url += "&accepts_incomplete=true"
+	}
 
-	req, err := http.NewRequest("DELETE", url, nil)
+	resp, resBody, err := broker.doRequest("DELETE", url, nil)
 	if err != nil {
-		return errwrap.Wrapf("Cannot construct HTTP request: {{err}}", err)
+		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Broker-Api-Version", broker.apiVersion)
-	req.SetBasicAuth(broker.username, broker.password)
+	// 410 Gone means the binding no longer exists, which is the desired outcome
+	if resp.StatusCode == http.StatusGone {
+		return nil
+	}
+	if err = responseError(resp, resBody); err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusAccepted {
+		return broker.waitForBinding(serviceID, planID, instanceID, bindingID, resBody)
+	}
+	return nil
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return errwrap.Wrapf("Failed doing HTTP request: {{err}}", err)
+// waitForBinding polls the last operation of a binding until an async bind
+// or unbind, started with the 202 Accepted response body, has finished
+func (broker *OpenServiceBroker) waitForBinding(serviceID, planID, instanceID, bindingID string, accepted []byte) error {
+	var asyncResp struct {
+		Operation string `json:"operation"`
 	}
-	defer resp.Body.Close()
+	json.Unmarshal(accepted, &asyncResp)
+
+	wait := broker.pollInterval
+	for {
+		time.Sleep(wait)
+		lastOpResp, retryAfter, err := broker.BindingLastOperation(serviceID, planID, instanceID, bindingID, asyncResp.Operation)
+		if err != nil {
+			return err
+		}
+		switch lastOpResp.State {
+		case brokerapi.Succeeded:
+			return nil
+		case brokerapi.Failed:
+			return fmt.Errorf("binding operation failed: %s", lastOpResp.Description)
+		}
+		wait = broker.pollInterval
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+	}
+}
+
+// BindingLastOperation fetches the status of the last operation performed upon
+// a service binding (OSB 2.14), and how long the broker asks to wait before
+// polling again, if it says
+func (broker *OpenServiceBroker) BindingLastOperation(serviceID, planID, instanceID, bindingID, operation string) (lastOpResp *brokerapi.LastOperationResponse, retryAfter time.Duration, err error) {
+	url := fmt.Sprintf("%s/v2/service_instances/%s/service_bindings/%s/last_operation?operation=%s&service_id=%s&plan_id=%s",
+		broker.url, instanceID, bindingID, operation, serviceID, planID)
 
-	resBody, err := ioutil.ReadAll(resp.Body)
+	resp, resBody, err := broker.doRequest("GET", url, nil)
 	if err != nil {
-		return errwrap.Wrapf("Failed reading HTTP response body: {{err}}", err)
+		return nil, 0, err
 	}
-	if resp.StatusCode >= 400 {
-		errorResp := &brokerapi.ErrorResponse{}
-		json.Unmarshal(resBody, errorResp)
-		return fmt.Errorf("API request error %d: %v", resp.StatusCode, errorResp)
+	// 410 Gone is how brokers report that unbinding has finished
+	if resp.StatusCode == http.StatusGone {
+		return &brokerapi.LastOperationResponse{State: brokerapi.Succeeded, Description: "binding no longer exists"}, 0, nil
 	}
-	return
+	if err = responseError(resp, resBody); err != nil {
+		return nil, 0, err
+	}
+	lastOpResp = &brokerapi.LastOperationResponse{}
+	if err = json.Unmarshal(resBody, lastOpResp); err != nil {
+		return nil, 0, errwrap.Wrapf("Failed unmarshalling last operation response: {{err}}", err)
+	}
+	return lastOpResp, broker.retryAfter(resp), nil
+}
+
+// retryAfter returns the delay of a Retry-After header in seconds (OSB 2.15), or 0
+func (broker *OpenServiceBroker) retryAfter(resp *http.Response) time.Duration {
+	if !broker.Supports(FeatureRetryAfter) {
+		return 0
+	}
+	seconds, err := strconv.Atoi(strings.TrimSpace(resp.Header.Get("Retry-After")))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
 }
 
 // Deprovision destroys the service instance
@@ -199,71 +591,50 @@ func (broker *OpenServiceBroker) Deprovision(serviceID, planID, instanceID strin
 	url := fmt.Sprintf("%s/v2/service_instances/%s?service_id=%s&plan_id=%s&accepts_incomplete=true",
 		broker.url, instanceID, serviceID, planID)
 
-	req, err := http.NewRequest("DELETE", url, nil)
-	if err != nil {
-		isAsync = false
-		err = errwrap.Wrapf("Cannot construct HTTP request: {{err}}", err)
-		return
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Broker-Api-Version", broker.apiVersion)
-	req.SetBasicAuth(broker.username, broker.password)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	deprovisioningResp = &brokerapi.DeprovisionResponse{}
-
-	resBody, err := ioutil.ReadAll(resp.Body)
+	resp, resBody, err := broker.doRequest("DELETE", url, nil)
 	if err != nil {
-		return nil, false, errwrap.Wrapf("Failed reading HTTP response body: {{err}}", err)
+		return nil, false, err
 	}
-
-	if err != nil {
-		isAsync = false
-		err = errwrap.Wrapf("Failed doing HTTP request: {{err}}", err)
-		return
+	// 410 Gone means the instance no longer exists, which is the desired outcome
+	if resp.StatusCode == http.StatusGone {
+		return &brokerapi.DeprovisionResponse{}, false, nil
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusAccepted {
-		isAsync = true
-	}
-	if resp.StatusCode >= 400 {
-		errorResp := &brokerapi.ErrorResponse{}
-		json.Unmarshal(resBody, errorResp)
-		return nil, false, fmt.Errorf("API request error %d: %v", resp.StatusCode, errorResp)
+	if err = responseError(resp, resBody); err != nil {
+		return nil, false, err
 	}
+	isAsync = resp.StatusCode == http.StatusAccepted
 
+	deprovisioningResp = &brokerapi.DeprovisionResponse{}
 	json.Unmarshal(resBody, deprovisioningResp)
 	return
 }
 
 // LastOperation fetches the status of the last operation perform upon a service instance
 func (broker *OpenServiceBroker) LastOperation(serviceID, planID, instanceID, operation string) (lastOpResp *brokerapi.LastOperationResponse, err error) {
-	url := fmt.Sprintf("%s/v2/service_instances/%s/last_operation?operation=%s&service_id=%s&plan_id=%s", broker.url, instanceID, operation, serviceID, planID)
+	lastOpResp, _, err = broker.PollLastOperation(serviceID, planID, instanceID, operation)
+	return
+}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, errwrap.Wrapf("Cannot construct HTTP request: {{err}}", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Broker-Api-Version", broker.apiVersion)
-	req.SetBasicAuth(broker.username, broker.password)
+// ErrGone is returned when polling the last operation of an instance that no
+// longer exists (410 Gone), which is how brokers report that deprovisioning
+// has finished
+var ErrGone = errors.New("instance no longer exists (410 Gone)")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, errwrap.Wrapf("Failed doing HTTP request: {{err}}", err)
-	}
-	defer resp.Body.Close()
+// PollLastOperation fetches the status of the last operation performed upon a
+// service instance, and how long the broker asks to wait before polling
+// again, if it says
+func (broker *OpenServiceBroker) PollLastOperation(serviceID, planID, instanceID, operation string) (lastOpResp *brokerapi.LastOperationResponse, retryAfter time.Duration, err error) {
+	url := fmt.Sprintf("%s/v2/service_instances/%s/last_operation?operation=%s&service_id=%s&plan_id=%s", broker.url, instanceID, operation, serviceID, planID)
 
-	resBody, err := ioutil.ReadAll(resp.Body)
+	resp, resBody, err := broker.doRequest("GET", url, nil)
 	if err != nil {
-		return nil, errwrap.Wrapf("Failed reading HTTP response body: {{err}}", err)
+		return nil, 0, err
+	}
+	if resp.StatusCode == http.StatusGone {
+		return nil, 0, ErrGone
 	}
-	if resp.StatusCode >= 400 {
-		errorResp := &brokerapi.ErrorResponse{}
-		json.Unmarshal(resBody, errorResp)
-		return nil, fmt.Errorf("API request error %d: %v", resp.StatusCode, errorResp)
+	if err = responseError(resp, resBody); err != nil {
+		return nil, 0, err
 	}
 
 	lastOpResp = &brokerapi.LastOperationResponse{}
@@ -273,6 +644,7 @@ func (broker *OpenServiceBroker) LastOperation(serviceID, planID, instanceID, op
 		lastOpResp.State = brokerapi.Succeeded
 		err = nil
 	}
+	retryAfter = broker.retryAfter(resp)
 
 	return
 }
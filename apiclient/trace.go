@@ -0,0 +1,311 @@
+package apiclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracer is an http.RoundTripper that logs each broker request and response.
+// With Verbosity 1 it logs method, URL, status and timing; with 2 or more it
+// also dumps headers and bodies. If TraceFile is set, every exchange is also
+// written to it as a HAR-like JSON log. Credentials are always redacted.
+type Tracer struct {
+	Transport http.RoundTripper
+	Verbosity int
+	Output    io.Writer
+	TraceFile string
+
+	mutex   sync.Mutex
+	entries []harEntry
+}
+
+type harLog struct {
+	Log struct {
+		Version string     `json:"version"`
+		Creator harCreator `json:"creator"`
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Error           string      `json:"_error,omitempty"`
+}
+
+type harRequest struct {
+	Method   string       `json:"method"`
+	URL      string       `json:"url"`
+	Headers  []harHeader  `json:"headers"`
+	PostData *harPostData `json:"postData,omitempty"`
+}
+
+type harResponse struct {
+	Status     int         `json:"status"`
+	StatusText string      `json:"statusText"`
+	Headers    []harHeader `json:"headers"`
+	Content    harContent  `json:"content"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// RoundTrip implements http.RoundTripper
+func (tracer *Tracer) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := tracer.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	started := time.Now()
+	resp, err := transport.RoundTrip(req)
+	elapsed := time.Since(started)
+
+	var resBody []byte
+	if err == nil {
+		resBody, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(resBody))
+	}
+
+	tracer.log(req, reqBody, resp, resBody, elapsed, err)
+	tracer.record(req, reqBody, resp, resBody, started, elapsed, err)
+	return resp, err
+}
+
+func (tracer *Tracer) log(req *http.Request, reqBody []byte, resp *http.Response, resBody []byte, elapsed time.Duration, err error) {
+	if tracer.Verbosity < 1 || tracer.Output == nil {
+		return
+	}
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+
+	out := tracer.Output
+	reqURL := redactURL(req.URL)
+	if err != nil {
+		fmt.Fprintf(out, "%s %s: %s (%s)\n", req.Method, reqURL, err, elapsed)
+		return
+	}
+	fmt.Fprintf(out, "%s %s %s (%s)\n", req.Method, reqURL, resp.Status, elapsed)
+	if tracer.Verbosity < 2 {
+		return
+	}
+	fmt.Fprintln(out, "> request headers:")
+	for _, header := range redactHeaders(req.Header) {
+		fmt.Fprintf(out, ">   %s: %s\n", header.Name, header.Value)
+	}
+	if len(reqBody) > 0 {
+		fmt.Fprintf(out, "> %s\n", redactBody(reqBody, req.Header.Get("Content-Type")))
+	}
+	fmt.Fprintln(out, "< response headers:")
+	for _, header := range redactHeaders(resp.Header) {
+		fmt.Fprintf(out, "<   %s: %s\n", header.Name, header.Value)
+	}
+	if len(resBody) > 0 {
+		fmt.Fprintf(out, "< %s\n", redactBody(resBody, resp.Header.Get("Content-Type")))
+	}
+}
+
+func (tracer *Tracer) record(req *http.Request, reqBody []byte, resp *http.Response, resBody []byte, started time.Time, elapsed time.Duration, err error) {
+	if tracer.TraceFile == "" {
+		return
+	}
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+
+	entry := harEntry{
+		StartedDateTime: started,
+		Time:            float64(elapsed) / float64(time.Millisecond),
+		Request: harRequest{
+			Method:  req.Method,
+			URL:     redactURL(req.URL),
+			Headers: redactHeaders(req.Header),
+		},
+	}
+	if len(reqBody) > 0 {
+		contentType := req.Header.Get("Content-Type")
+		entry.Request.PostData = &harPostData{MimeType: contentType, Text: redactBody(reqBody, contentType)}
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		contentType := resp.Header.Get("Content-Type")
+		entry.Response = harResponse{
+			Status:     resp.StatusCode,
+			StatusText: http.StatusText(resp.StatusCode),
+			Headers:    redactHeaders(resp.Header),
+			Content: harContent{
+				Size:     len(resBody),
+				MimeType: contentType,
+				Text:     redactBody(resBody, contentType),
+			},
+		}
+	}
+	tracer.entries = append(tracer.entries, entry)
+
+	// the whole file is rewritten each time so it is complete even if eden exits abruptly
+	var har harLog
+	har.Log.Version = "1.2"
+	har.Log.Creator = harCreator{Name: "eden", Version: "1"}
+	har.Log.Entries = tracer.entries
+	bytes, err := json.MarshalIndent(har, "", "  ")
+	if err != nil {
+		return
+	}
+	ioutil.WriteFile(tracer.TraceFile, bytes, 0600)
+}
+
+const redacted = "[REDACTED]"
+
+// sensitiveKey reports whether a header, query, form or JSON key is likely to hold a secret
+func sensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range []string{"authorization", "credentials", "password", "secret", "token", "cookie"} {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactHeaders returns headers sorted by name, with secret values redacted
+func redactHeaders(headers http.Header) []harHeader {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]harHeader, 0, len(headers))
+	for _, name := range names {
+		for _, value := range headers[name] {
+			if sensitiveKey(name) {
+				value = redacted
+			}
+			result = append(result, harHeader{Name: name, Value: value})
+		}
+	}
+	return result
+}
+
+// redactURL returns the URL with any secret userinfo or query parameters redacted
+func redactURL(u *url.URL) string {
+	copied := *u
+	if copied.User != nil {
+		copied.User = url.User(copied.User.Username())
+	}
+	query := copied.Query()
+	changed := false
+	for key := range query {
+		if sensitiveKey(key) {
+			query.Set(key, redacted)
+			changed = true
+		}
+	}
+	if changed {
+		copied.RawQuery = query.Encode()
+	}
+	return copied.String()
+}
+
+// redactBody returns a JSON or form encoded body with secret values redacted
+func redactBody(body []byte, contentType string) string {
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return string(body)
+		}
+		for key := range form {
+			if sensitiveKey(key) {
+				form.Set(key, redacted)
+			}
+		}
+		return form.Encode()
+	}
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return strings.TrimSpace(string(body))
+	}
+	bytes, err := json.Marshal(redactJSON(data))
+	if err != nil {
+		return strings.TrimSpace(string(body))
+	}
+	return string(bytes)
+}
+
+// RedactParameters returns JSON parameters with secret values redacted, so
+// that they can be kept without storing passwords in plain text
+func RedactParameters(parameters json.RawMessage) json.RawMessage {
+	var data interface{}
+	if len(parameters) == 0 || json.Unmarshal(parameters, &data) != nil {
+		return parameters
+	}
+	bytes, err := json.Marshal(redactJSON(data))
+	if err != nil {
+		return parameters
+	}
+	return bytes
+}
+
+func redactJSON(data interface{}) interface{} {
+	switch value := data.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			if sensitiveKey(key) {
+				result[key] = redacted
+			} else {
+				result[key] = redactJSON(item)
+			}
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, item := range value {
+			result[i] = redactJSON(item)
+		}
+		return result
+	default:
+		return data
+	}
+}
@@ -0,0 +1,112 @@
+package apiclient
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SupportedAPIVersions are the OSB API versions this client speaks, newest first
+var SupportedAPIVersions = []string{"2.16", "2.15", "2.14", "2.13", "2.12", "2.11"}
+
+// DefaultAPIVersion is used when no version could be negotiated with a broker
+const DefaultAPIVersion = "2.13"
+
+// Feature is a part of the OSB API that only newer brokers support
+type Feature struct {
+	Name       string `json:"name"`
+	MinVersion string `json:"min_api_version"`
+}
+
+// Features gated on the negotiated API version
+var (
+	FeatureGetEndpoints    = Feature{Name: "GET instance and binding endpoints", MinVersion: "2.14"}
+	FeatureAsyncBindings   = Feature{Name: "asynchronous bindings", MinVersion: "2.14"}
+	FeatureMaintenanceInfo = Feature{Name: "maintenance_info", MinVersion: "2.15"}
+	FeatureRetryAfter      = Feature{Name: "Retry-After when polling", MinVersion: "2.15"}
+)
+
+// Features lists all features gated on the API version
+var Features = []Feature{FeatureGetEndpoints, FeatureAsyncBindings, FeatureMaintenanceInfo, FeatureRetryAfter}
+
+// APIVersion returns the API version sent to the broker
+func (broker *OpenServiceBroker) APIVersion() string {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	return broker.apiVersion
+}
+
+// SetAPIVersion sets the API version sent to the broker
+func (broker *OpenServiceBroker) SetAPIVersion(version string) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	broker.apiVersion = version
+}
+
+// Supports returns true if the broker's API version includes feature
+func (broker *OpenServiceBroker) Supports(feature Feature) bool {
+	return compareAPIVersions(broker.APIVersion(), feature.MinVersion) >= 0
+}
+
+// unsupported returns the error for using a feature the broker does not support
+func (broker *OpenServiceBroker) unsupported(feature Feature) error {
+	return fmt.Errorf("%s requires OSB API %s or later; broker uses %s", feature.Name, feature.MinVersion, broker.APIVersion())
+}
+
+// OnNegotiate sets a callback for when an API version has been negotiated,
+// and enables negotiating a new one when the broker rejects the current one
+// with 412 Precondition Failed
+func (broker *OpenServiceBroker) OnNegotiate(callback func(version string)) {
+	broker.onNegotiate = callback
+}
+
+// NegotiateAPIVersion fetches the catalog with each supported API version,
+// newest first, until the broker accepts one, and then uses that version.
+// The catalog is kept, so Catalog does not need to fetch it again.
+func (broker *OpenServiceBroker) NegotiateAPIVersion() (string, error) {
+	for _, version := range SupportedAPIVersions {
+		resp, resBody, err := broker.sendOnce("GET", broker.url+"/v2/catalog", version, nil, nil)
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode == http.StatusPreconditionFailed {
+			continue
+		}
+		if err = responseError(resp, resBody); err != nil {
+			return "", err
+		}
+		broker.SetAPIVersion(version)
+		if catalog, err := broker.decodeCatalog(resBody); err == nil {
+			broker.setCatalog(catalog)
+		}
+		if broker.onNegotiate != nil {
+			broker.onNegotiate(version)
+		}
+		return version, nil
+	}
+	return "", fmt.Errorf("broker does not support any OSB API version from %s to %s",
+		SupportedAPIVersions[len(SupportedAPIVersions)-1], SupportedAPIVersions[0])
+}
+
+// compareAPIVersions compares major.minor versions like strings.Compare
+func compareAPIVersions(a, b string) int {
+	aMajor, aMinor := parseAPIVersion(a)
+	bMajor, bMinor := parseAPIVersion(b)
+	switch {
+	case aMajor != bMajor && aMajor < bMajor, aMajor == bMajor && aMinor < bMinor:
+		return -1
+	case aMajor == bMajor && aMinor == bMinor:
+		return 0
+	}
+	return 1
+}
+
+func parseAPIVersion(version string) (major, minor int) {
+	parts := strings.SplitN(version, ".", 2)
+	major, _ = strconv.Atoi(parts[0])
+	if len(parts) == 2 {
+		minor, _ = strconv.Atoi(parts[1])
+	}
+	return major, minor
+}
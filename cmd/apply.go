@@ -0,0 +1,431 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/jhunt/go-table"
+	"github.com/pborman/uuid"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/starkandwayne/eden/apiclient"
+	edenstore "github.com/starkandwayne/eden/store"
+	"gopkg.in/yaml.v2"
+)
+
+// ApplyOpts represents the 'apply' command
+type ApplyOpts struct {
+	File     string `short:"f" long:"file" description:"Manifest of service instances and bindings" required:"true"`
+	Prune    bool   `long:"prune" description:"Also unbind/deprovision instances and bindings of this broker that are not in the manifest, except protected instances"`
+	Parallel int    `long:"parallel" description:"Maximum number of instances to change concurrently" default:"5"`
+}
+
+// applyManifest describes the desired service instances and their bindings
+type applyManifest struct {
+	Instances []applyManifestInstance `yaml:"instances"`
+}
+
+type applyManifestInstance struct {
+	Name       string                 `yaml:"name"`
+	Service    string                 `yaml:"service"`
+	Plan       string                 `yaml:"plan"`
+	Parameters map[string]interface{} `yaml:"parameters"`
+	Bindings   []applyManifestBinding `yaml:"bindings"`
+}
+
+type applyManifestBinding struct {
+	Name       string                 `yaml:"name"`
+	Parameters map[string]interface{} `yaml:"parameters"`
+}
+
+// applyAction is one step needed to converge the local store and broker with the manifest
+type applyAction struct {
+	Action   string `json:"action"`
+	Instance string `json:"instance"`
+	Binding  string `json:"binding,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+
+	instanceID     string
+	serviceID      string
+	serviceName    string
+	planID         string
+	planName       string
+	previousPlanID string
+	bindingID      string
+	parameters     json.RawMessage
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c ApplyOpts) Execute(_ []string) (err error) {
+	bytes, err := ioutil.ReadFile(c.File)
+	if err != nil {
+		return errwrap.Wrapf("Could not read manifest: {{err}}", err)
+	}
+	manifest := applyManifest{}
+	if err = yaml.Unmarshal(bytes, &manifest); err != nil {
+		return errwrap.Wrapf("Could not parse manifest: {{err}}", err)
+	}
+
+	broker, err := Opts.broker()
+	if err != nil {
+		return err
+	}
+	groups, err := c.plan(broker, manifest)
+	if err != nil {
+		return err
+	}
+	actions := []*applyAction{}
+	for _, group := range groups {
+		actions = append(actions, group...)
+	}
+
+	if Opts.DryRun != "" || len(actions) == 0 {
+		return render(actions, func() error {
+			if len(actions) == 0 {
+				fmt.Println("apply: nothing to do")
+				return nil
+			}
+			return printApplyActions(actions)
+		})
+	}
+
+	c.converge(broker, groups)
+
+	failed := 0
+	for _, action := range actions {
+		if action.Status != "done" {
+			failed++
+		}
+	}
+	err = render(actions, func() error {
+		return printApplyActions(actions)
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("apply: %d of %d changes did not complete", failed, len(actions))
+	}
+	return nil
+}
+
+// plan compares the manifest to the local store and returns the actions
+// needed, grouped by instance; groups are independent of each other
+func (c ApplyOpts) plan(broker *apiclient.OpenServiceBroker, manifest applyManifest) ([][]*applyAction, error) {
+	config := Opts.config()
+	groups := [][]*applyAction{}
+	wanted := map[string]bool{}
+
+	for _, item := range manifest.Instances {
+		if item.Name == "" {
+			return nil, fmt.Errorf("Manifest instances require a name")
+		}
+		if wanted[item.Name] {
+			return nil, fmt.Errorf("Manifest instance '%s' is listed more than once", item.Name)
+		}
+		wanted[item.Name] = true
+
+		service, err := broker.FindServiceByNameOrID(item.Service)
+		if err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("Instance '%s': {{err}}", item.Name), err)
+		}
+		plan, err := broker.FindPlanByNameOrID(service, item.Plan)
+		if err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("Instance '%s': {{err}}", item.Name), err)
+		}
+		parameters, err := manifestParameters(item.Parameters)
+		if err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("Instance '%s' parameters: {{err}}", item.Name), err)
+		}
+
+		existing := findInstanceByName(config, item.Name)
+		instanceID := uuid.New()
+		if existing != nil {
+			instanceID = existing.ID
+		} else if other := config.FindServiceInstance(item.Name); other.ServiceID != "" {
+			// instance names are unique in the config file
+			return nil, fmt.Errorf("Instance '%s' already exists on broker %s; apply only manages instances of the target broker", item.Name, other.BrokerURL)
+		}
+
+		group := []*applyAction{}
+		newAction := func(action, detail string) *applyAction {
+			return &applyAction{
+				Action:      action,
+				Instance:    item.Name,
+				Detail:      detail,
+				Status:      "planned",
+				instanceID:  instanceID,
+				serviceID:   service.ID,
+				serviceName: service.Name,
+				planID:      plan.ID,
+				planName:    plan.Name,
+				parameters:  parameters,
+			}
+		}
+
+		if existing == nil {
+			group = append(group, newAction("provision", fmt.Sprintf("%s/%s", service.Name, plan.Name)))
+		} else {
+			if existing.ServiceID != service.ID {
+				return nil, fmt.Errorf("Instance '%s' is a '%s' service and cannot be changed to '%s'", item.Name, existing.ServiceName, service.Name)
+			}
+			if existing.PlanID != plan.ID || !sameJSON(existing.Parameters, string(apiclient.RedactParameters(parameters))) {
+				action := newAction("update", fmt.Sprintf("%s/%s", service.Name, plan.Name))
+				if existing.PlanID != plan.ID {
+					action.Detail = fmt.Sprintf("%s -> %s", existing.PlanName, plan.Name)
+				}
+				action.previousPlanID = existing.PlanID
+				group = append(group, action)
+			}
+		}
+
+		wantedBindings := map[string]bool{}
+		for _, binding := range item.Bindings {
+			if binding.Name == "" {
+				return nil, fmt.Errorf("Instance '%s' has a binding without a name", item.Name)
+			}
+			wantedBindings[binding.Name] = true
+			if existing != nil && existing.FindServiceBinding(binding.Name) != -1 {
+				continue
+			}
+			bindingParameters, err := manifestParameters(binding.Parameters)
+			if err != nil {
+				return nil, errwrap.Wrapf(fmt.Sprintf("Binding '%s' parameters: {{err}}", binding.Name), err)
+			}
+			action := newAction("bind", "")
+			action.Binding = binding.Name
+			action.bindingID = uuid.New()
+			action.parameters = bindingParameters
+			group = append(group, action)
+		}
+		if existing != nil && c.Prune {
+			for _, binding := range existing.Bindings {
+				if !wantedBindings[binding.Name] {
+					action := newAction("unbind", "not in manifest")
+					action.Binding = binding.Name
+					action.bindingID = binding.ID
+					group = append(group, action)
+				}
+			}
+		}
+
+		if len(group) > 0 {
+			groups = append(groups, group)
+		}
+	}
+
+	if c.Prune {
+		for _, inst := range config.ServiceInstances() {
+			if wanted[inst.Name] || !Opts.isTargetBroker(inst.BrokerURL) || inst.Protected {
+				continue
+			}
+			group := []*applyAction{}
+			for _, binding := range inst.Bindings {
+				group = append(group, &applyAction{
+					Action: "unbind", Instance: inst.Name, Binding: binding.Name, Detail: "not in manifest", Status: "planned",
+					instanceID: inst.ID, serviceID: inst.ServiceID, planID: inst.PlanID, bindingID: binding.ID,
+				})
+			}
+			group = append(group, &applyAction{
+				Action: "deprovision", Instance: inst.Name, Detail: "not in manifest", Status: "planned",
+				instanceID: inst.ID, serviceID: inst.ServiceID, planID: inst.PlanID,
+			})
+			groups = append(groups, group)
+		}
+	}
+	return groups, nil
+}
+
+// converge runs the groups of actions concurrently, up to --parallel at a time;
+// within a group actions run in order and stop at the first failure
+func (c ApplyOpts) converge(broker *apiclient.OpenServiceBroker, groups [][]*applyAction) {
+	parallel := c.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	work := make(chan []*applyAction)
+	wg := sync.WaitGroup{}
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range work {
+				failed := false
+				for _, action := range group {
+					if failed {
+						action.Status = "skipped"
+						continue
+					}
+					progress("apply: %s %s %s\n", action.Action, action.Instance, action.Binding)
+					if err := c.run(broker, action); err != nil {
+						action.Status = "failed"
+						action.Error = err.Error()
+						progress("apply: %s %s %s failed: %s\n", action.Action, action.Instance, action.Binding, err)
+						failed = true
+						continue
+					}
+					action.Status = "done"
+				}
+			}
+		}()
+	}
+	for _, group := range groups {
+		work <- group
+	}
+	close(work)
+	wg.Wait()
+}
+
+func (c ApplyOpts) run(broker *apiclient.OpenServiceBroker, action *applyAction) error {
+	switch action.Action {
+	case "provision":
+		resp, isAsync, err := broker.Provision(action.serviceID, action.planID, action.instanceID, action.parameters)
+		if err != nil {
+			return err
+		}
+		err = Opts.config().ProvisionNewServiceInstance(action.instanceID, action.Instance,
+			action.serviceID, action.serviceName, action.planID, action.planName, Opts.Broker.URLOpt)
+		if err != nil {
+			return err
+		}
+		err = Opts.config().UpdateServiceInstance(action.instanceID, action.planID, action.planName, apiclient.RedactParameters(action.parameters))
+		if err != nil {
+			return err
+		}
+		if err = recordMaintenanceInfo(broker, action.instanceID, action.planID); err != nil {
+			return err
+		}
+		if isAsync {
+			return c.wait(broker, action, resp.OperationData)
+		}
+		return Opts.config().RecordLastOperation(action.instanceID, action.Action, string(brokerapi.Succeeded), "")
+	case "update":
+		resp, isAsync, err := broker.Update(action.serviceID, action.planID, action.previousPlanID, action.instanceID, action.parameters)
+		if err != nil {
+			return err
+		}
+		if isAsync {
+			if err = c.wait(broker, action, resp.OperationData); err != nil {
+				return err
+			}
+		} else if err = Opts.config().RecordLastOperation(action.instanceID, action.Action, string(brokerapi.Succeeded), ""); err != nil {
+			return err
+		}
+		return Opts.config().UpdateServiceInstance(action.instanceID, action.planID, action.planName, apiclient.RedactParameters(action.parameters))
+	case "bind":
+		resp, err := broker.Bind(action.serviceID, action.planID, action.instanceID, action.bindingID, action.parameters)
+		if err != nil {
+			return err
+		}
+		return Opts.config().BindServiceInstance(action.instanceID, action.bindingID, action.Binding, resp.Credentials, bindingDetails(resp))
+	case "unbind":
+		if err := broker.Unbind(action.serviceID, action.planID, action.instanceID, action.bindingID); err != nil {
+			return err
+		}
+		return Opts.config().UnbindServiceInstance(action.instanceID, action.bindingID)
+	case "deprovision":
+		resp, isAsync, err := broker.Deprovision(action.serviceID, action.planID, action.instanceID)
+		if err != nil {
+			return err
+		}
+		if isAsync {
+			if err = c.wait(broker, action, resp.OperationData); err != nil {
+				return err
+			}
+		}
+		return Opts.config().DeprovisionServiceInstance(action.instanceID)
+	}
+	return nil
+}
+
+func (c ApplyOpts) wait(broker *apiclient.OpenServiceBroker, action *applyAction, operation string) error {
+	lastOpResp, err := waitForLastOperation(broker, action.Action, action.serviceID, action.planID, action.instanceID, operation,
+		func(lastOpResp *brokerapi.LastOperationResponse) {
+			progress("apply: %s %s - %s %s\n", action.Action, action.Instance, lastOpResp.State, lastOpResp.Description)
+		})
+	if err != nil {
+		return err
+	}
+	if action.Action != "deprovision" {
+		err = Opts.config().RecordLastOperation(action.instanceID, action.Action, string(lastOpResp.State), lastOpResp.Description)
+		if err != nil {
+			return err
+		}
+	}
+	return lastOperationError(lastOpResp)
+}
+
+func printApplyActions(actions []*applyAction) error {
+	table := table.NewTable("Action", "Instance", "Binding", "Detail", "Status")
+	for _, action := range actions {
+		status := action.Status
+		if action.Error != "" {
+			status = fmt.Sprintf("%s: %s", action.Status, action.Error)
+		}
+		table.Row(nil, action.Action, action.Instance, action.Binding, action.Detail, status)
+	}
+	table.Output(os.Stdout)
+	return nil
+}
+
+// findInstanceByName finds an instance of the target broker by name
+func findInstanceByName(config edenstore.FSConfig, name string) *edenstore.FSServiceInstance {
+	for _, inst := range config.ServiceInstances() {
+		if inst.Name == name && Opts.isTargetBroker(inst.BrokerURL) {
+			return inst
+		}
+	}
+	return nil
+}
+
+// manifestParameters converts YAML parameters into JSON
+func manifestParameters(parameters map[string]interface{}) (json.RawMessage, error) {
+	if len(parameters) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(jsonCompatible(parameters))
+}
+
+// jsonCompatible converts the map[interface{}]interface{} produced by YAML into map[string]interface{}
+func jsonCompatible(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := map[string]interface{}{}
+		for key, item := range v {
+			result[fmt.Sprintf("%v", key)] = jsonCompatible(item)
+		}
+		return result
+	case map[string]interface{}:
+		result := map[string]interface{}{}
+		for key, item := range v {
+			result[key] = jsonCompatible(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = jsonCompatible(item)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+// sameJSON compares two JSON documents, treating empty as equal to {} or null
+func sameJSON(a, b string) bool {
+	normalize := func(s string) string {
+		var value interface{}
+		if s == "" || json.Unmarshal([]byte(s), &value) != nil || value == nil {
+			return "{}"
+		}
+		bytes, _ := json.Marshal(value)
+		return string(bytes)
+	}
+	return normalize(a) == normalize(b)
+}
@@ -0,0 +1,496 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/jhunt/go-table"
+	"github.com/pborman/uuid"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/starkandwayne/eden/apiclient"
+)
+
+// BenchOpts represents the 'bench' command
+type BenchOpts struct {
+	ServiceNameOrID string        `short:"s" long:"service-name" description:"Service name/ID from catalog (default: first)"`
+	PlanNameOrID    string        `short:"p" long:"plan-name" description:"Plan name/ID from catalog (default: first)"`
+	Parameters      string        `short:"P" long:"parameters" description:"parameters in json format. To use a file as input, prepend the filename with '@' (-P=@data.json)"`
+	Mix             string        `long:"mix" description:"Relative weights of the operations to run" default:"catalog=4,provision=1,bind=2,unbind=2,deprovision=1"`
+	Concurrency     int           `short:"c" long:"concurrency" description:"Number of concurrent workers" default:"4"`
+	Rate            float64       `short:"r" long:"rate" description:"Maximum operations per second across all workers (default: unlimited)"`
+	Duration        time.Duration `short:"d" long:"duration" description:"How long to run operations for" default:"30s"`
+	PollInterval    time.Duration `long:"poll-interval" description:"Time between last_operation requests for async operations" default:"1s"`
+}
+
+var benchOperations = []string{"catalog", "provision", "bind", "unbind", "deprovision"}
+
+// benchStats are the measurements of one operation
+type benchStats struct {
+	Operation  string         `json:"operation"`
+	Count      int            `json:"count"`
+	Errors     int            `json:"errors"`
+	P50        time.Duration  `json:"p50_ns"`
+	P90        time.Duration  `json:"p90_ns"`
+	P99        time.Duration  `json:"p99_ns"`
+	Max        time.Duration  `json:"max_ns"`
+	ErrorCodes map[string]int `json:"error_codes,omitempty"`
+	latencies  []time.Duration
+}
+
+// benchResult is the structured output of the 'bench' command
+type benchResult struct {
+	Duration   time.Duration `json:"duration_ns"`
+	Operations []*benchStats `json:"operations"`
+	Leftover   []string      `json:"leftover_instance_ids,omitempty"`
+}
+
+// benchRun holds the state shared by the workers of a benchmark
+type benchRun struct {
+	opts       BenchOpts
+	broker     *apiclient.OpenServiceBroker
+	service    *brokerapi.Service
+	plan       *brokerapi.ServicePlan
+	parameters []byte
+	weights    map[string]int
+	total      int
+
+	mutex     sync.Mutex
+	stats     map[string]*benchStats
+	instances map[string][]string // instance ID => binding IDs; only instances that finished provisioning
+	busy      map[string]bool     // instances being deprovisioned, or with a bind/unbind in flight
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c BenchOpts) Execute(_ []string) (err error) {
+	weights, total, err := parseBenchMix(c.Mix)
+	if err != nil {
+		return err
+	}
+	parameters, err := parseParameters(c.Parameters)
+	if err != nil {
+		return err
+	}
+	broker, err := Opts.broker()
+	if err != nil {
+		return err
+	}
+	catalog, err := broker.Catalog()
+	if err != nil {
+		return errwrap.Wrapf("Could not fetch catalog: {{err}}", err)
+	}
+	if len(catalog.Services) == 0 {
+		return fmt.Errorf("bench: the catalog has no services")
+	}
+	service := &catalog.Services[0]
+	if c.ServiceNameOrID != "" {
+		if service, err = broker.FindServiceByNameOrID(c.ServiceNameOrID); err != nil {
+			return errwrap.Wrapf("Could not find service in catalog: {{err}}", err)
+		}
+	}
+	plan, err := broker.FindPlanByNameOrID(service, c.PlanNameOrID)
+	if err != nil {
+		return errwrap.Wrapf("Could not find plan in service: {{err}}", err)
+	}
+	if c.PollInterval > 0 {
+		pollInterval = c.PollInterval
+		broker.SetPollInterval(pollInterval)
+	}
+
+	run := &benchRun{
+		opts:       c,
+		broker:     broker,
+		service:    service,
+		plan:       plan,
+		parameters: parameters,
+		weights:    weights,
+		total:      total,
+		stats:      map[string]*benchStats{},
+		instances:  map[string][]string{},
+		busy:       map[string]bool{},
+	}
+	progress("bench: %s/%s with %d workers for %s\n", service.Name, plan.Name, c.Concurrency, c.Duration)
+	started := time.Now()
+	run.runWorkers()
+	elapsed := time.Since(started)
+	progress("bench: tearing down %d instance(s)\n", len(run.instances))
+	leftover := run.teardown()
+
+	result := benchResult{Duration: elapsed, Leftover: leftover}
+	for _, name := range append(benchOperations, "provision (async)", "deprovision (async)") {
+		if stats, ok := run.stats[name]; ok {
+			stats.summarize()
+			result.Operations = append(result.Operations, stats)
+		}
+	}
+	err = render(result, func() error {
+		table := table.NewTable("Operation", "Count", "Errors", "Rate", "p50", "p90", "p99", "Max")
+		for _, stats := range result.Operations {
+			table.Row(nil, stats.Operation, strconv.Itoa(stats.Count), strconv.Itoa(stats.Errors),
+				fmt.Sprintf("%.1f/s", float64(stats.Count)/elapsed.Seconds()),
+				stats.P50.Round(time.Millisecond).String(), stats.P90.Round(time.Millisecond).String(),
+				stats.P99.Round(time.Millisecond).String(), stats.Max.Round(time.Millisecond).String())
+		}
+		table.Output(os.Stdout)
+		for _, stats := range result.Operations {
+			codes := []string{}
+			for code := range stats.ErrorCodes {
+				codes = append(codes, code)
+			}
+			sort.Strings(codes)
+			for _, code := range codes {
+				fmt.Printf("%s errors: %d x %s\n", stats.Operation, stats.ErrorCodes[code], code)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(leftover) > 0 {
+		return fmt.Errorf("bench: could not deprovision instances: %s", strings.Join(leftover, ", "))
+	}
+	return nil
+}
+
+// parseBenchMix parses weights such as "catalog=4,provision=1"
+func parseBenchMix(mix string) (map[string]int, int, error) {
+	weights := map[string]int{}
+	total := 0
+	for _, item := range strings.Split(mix, ",") {
+		parts := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		weight := 1
+		if len(parts) == 2 {
+			var err error
+			if weight, err = strconv.Atoi(parts[1]); err != nil || weight < 0 {
+				return nil, 0, fmt.Errorf("bench --mix '%s' has an invalid weight", item)
+			}
+		}
+		known := false
+		for _, name := range benchOperations {
+			known = known || name == parts[0]
+		}
+		if !known {
+			return nil, 0, fmt.Errorf("bench --mix operation '%s' must be one of: %s", parts[0], strings.Join(benchOperations, ", "))
+		}
+		weights[parts[0]] = weight
+		total += weight
+	}
+	if total == 0 {
+		return nil, 0, fmt.Errorf("bench --mix must have at least one operation with a weight above 0")
+	}
+	return weights, total, nil
+}
+
+// runWorkers runs operations on --concurrency workers until --duration has
+// passed, limited to --rate operations per second
+func (run *benchRun) runWorkers() {
+	deadline := time.Now().Add(run.opts.Duration)
+	var ticks <-chan time.Time
+	if run.opts.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / run.opts.Rate))
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	wg := sync.WaitGroup{}
+	concurrency := run.opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(random *rand.Rand) {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				if ticks != nil {
+					select {
+					case <-ticks:
+					case <-time.After(time.Until(deadline)):
+						return
+					}
+				}
+				run.runOperation(run.pickOperation(random))
+			}
+		}(rand.New(rand.NewSource(time.Now().UnixNano() + int64(i))))
+	}
+	wg.Wait()
+}
+
+// pickOperation picks an operation by weight
+func (run *benchRun) pickOperation(random *rand.Rand) string {
+	n := random.Intn(run.total)
+	for _, name := range benchOperations {
+		if n < run.weights[name] {
+			return name
+		}
+		n -= run.weights[name]
+	}
+	return "catalog"
+}
+
+// runOperation runs an operation, or the operation it depends on if there is
+// nothing to run it on yet (e.g. provision instead of bind)
+func (run *benchRun) runOperation(operation string) {
+	switch operation {
+	case "catalog":
+		run.measure("catalog", func() error {
+			_, err := run.broker.FetchCatalog()
+			return err
+		})
+	case "provision":
+		run.provision()
+	case "bind":
+		instanceID := run.claimInstance(false)
+		if instanceID == "" {
+			run.provision()
+			return
+		}
+		defer run.release(instanceID)
+		bindingID := uuid.New()
+		err := run.measure("bind", func() error {
+			_, err := run.broker.Bind(run.service.ID, run.plan.ID, instanceID, bindingID, nil)
+			return err
+		})
+		if err == nil {
+			run.mutex.Lock()
+			run.instances[instanceID] = append(run.instances[instanceID], bindingID)
+			run.mutex.Unlock()
+		}
+	case "unbind":
+		instanceID := run.claimInstance(true)
+		if instanceID == "" {
+			run.runOperation("bind")
+			return
+		}
+		defer run.release(instanceID)
+		run.unbind(instanceID, true)
+	case "deprovision":
+		instanceID := run.claimInstance(false)
+		if instanceID == "" {
+			run.provision()
+			return
+		}
+		defer run.release(instanceID)
+		for len(run.bindings(instanceID)) > 0 {
+			if run.unbind(instanceID, true) != nil {
+				return
+			}
+		}
+		run.deprovision(instanceID, true)
+	}
+}
+
+// measure times an operation and records its latency, or its error
+func (run *benchRun) measure(operation string, fn func() error) error {
+	started := time.Now()
+	err := fn()
+	run.record(operation, time.Since(started), err)
+	return err
+}
+
+func (run *benchRun) record(operation string, latency time.Duration, err error) {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+	stats, ok := run.stats[operation]
+	if !ok {
+		stats = &benchStats{Operation: operation, ErrorCodes: map[string]int{}}
+		run.stats[operation] = stats
+	}
+	stats.Count++
+	stats.latencies = append(stats.latencies, latency)
+	if err != nil {
+		stats.Errors++
+		code := "other"
+		var apiErr *apiclient.APIError
+		var requestErr *apiclient.RequestError
+		var opErr *operationError
+		switch {
+		case errors.As(err, &apiErr):
+			code = strconv.Itoa(apiErr.StatusCode)
+		case errors.As(err, &requestErr):
+			code = "network"
+		case errors.As(err, &opErr):
+			code = "operation failed"
+		}
+		stats.ErrorCodes[code]++
+	}
+}
+
+// summarize computes the latency percentiles
+func (stats *benchStats) summarize() {
+	sort.Slice(stats.latencies, func(i, j int) bool { return stats.latencies[i] < stats.latencies[j] })
+	percentile := func(p float64) time.Duration {
+		if len(stats.latencies) == 0 {
+			return 0
+		}
+		i := int(math.Ceil(p*float64(len(stats.latencies)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return stats.latencies[i]
+	}
+	stats.P50 = percentile(0.5)
+	stats.P90 = percentile(0.9)
+	stats.P99 = percentile(0.99)
+	stats.Max = percentile(1)
+}
+
+// provision creates an instance, which is available to other operations
+// once provisioning has finished
+func (run *benchRun) provision() {
+	instanceID := uuid.New()
+	started := time.Now()
+	var resp *brokerapi.ProvisioningResponse
+	var isAsync bool
+	err := run.measure("provision", func() (err error) {
+		resp, isAsync, err = run.broker.Provision(run.service.ID, run.plan.ID, instanceID, run.parameters)
+		return err
+	})
+	if err != nil {
+		return
+	}
+	if isAsync {
+		err = run.wait("provision", instanceID, resp.OperationData)
+		run.record("provision (async)", time.Since(started), err)
+	}
+	// also tear down instances that failed to provision
+	run.mutex.Lock()
+	run.instances[instanceID] = []string{}
+	run.mutex.Unlock()
+}
+
+// unbind removes the most recent binding of an instance
+func (run *benchRun) unbind(instanceID string, measure bool) error {
+	bindings := run.bindings(instanceID)
+	bindingID := bindings[len(bindings)-1]
+	unbind := func() error {
+		return run.broker.Unbind(run.service.ID, run.plan.ID, instanceID, bindingID)
+	}
+	var err error
+	if measure {
+		err = run.measure("unbind", unbind)
+	} else {
+		err = unbind()
+	}
+	if err == nil {
+		run.mutex.Lock()
+		run.instances[instanceID] = bindings[:len(bindings)-1]
+		run.mutex.Unlock()
+	}
+	return err
+}
+
+// deprovision deletes an instance, which must not have bindings
+func (run *benchRun) deprovision(instanceID string, measure bool) error {
+	started := time.Now()
+	var resp *brokerapi.DeprovisionResponse
+	var isAsync bool
+	deprovision := func() (err error) {
+		resp, isAsync, err = run.broker.Deprovision(run.service.ID, run.plan.ID, instanceID)
+		return err
+	}
+	var err error
+	if measure {
+		err = run.measure("deprovision", deprovision)
+	} else {
+		err = deprovision()
+	}
+	if err == nil && isAsync {
+		err = run.wait("deprovision", instanceID, resp.OperationData)
+		if measure {
+			run.record("deprovision (async)", time.Since(started), err)
+		}
+	}
+	if err == nil {
+		run.mutex.Lock()
+		delete(run.instances, instanceID)
+		run.mutex.Unlock()
+	}
+	return err
+}
+
+func (run *benchRun) wait(operationType, instanceID, operation string) error {
+	lastOpResp, err := waitForLastOperation(run.broker, operationType, run.service.ID, run.plan.ID, instanceID, operation, nil)
+	if err != nil {
+		return err
+	}
+	return lastOperationError(lastOpResp)
+}
+
+// claimInstance reserves a random instance that no other worker is using,
+// optionally one that has bindings
+func (run *benchRun) claimInstance(withBindings bool) string {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+	for instanceID, bindings := range run.instances {
+		if !run.busy[instanceID] && (!withBindings || len(bindings) > 0) {
+			run.busy[instanceID] = true
+			return instanceID
+		}
+	}
+	return ""
+}
+
+func (run *benchRun) release(instanceID string) {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+	delete(run.busy, instanceID)
+}
+
+func (run *benchRun) bindings(instanceID string) []string {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+	return run.instances[instanceID]
+}
+
+// teardown unbinds and deprovisions everything created by the benchmark,
+// returning the IDs of instances that could not be deprovisioned
+func (run *benchRun) teardown() []string {
+	work := make(chan string)
+	wg := sync.WaitGroup{}
+	leftover := []string{}
+	mutex := sync.Mutex{}
+	for i := 0; i < run.opts.Concurrency || i == 0; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for instanceID := range work {
+				var err error
+				for err == nil && len(run.bindings(instanceID)) > 0 {
+					err = run.unbind(instanceID, false)
+				}
+				if err == nil {
+					err = run.deprovision(instanceID, false)
+				}
+				if err != nil {
+					progress("bench: could not tear down %s: %s\n", instanceID, err)
+					mutex.Lock()
+					leftover = append(leftover, instanceID)
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	run.mutex.Lock()
+	instanceIDs := []string{}
+	for instanceID := range run.instances {
+		instanceIDs = append(instanceIDs, instanceID)
+	}
+	run.mutex.Unlock()
+	for _, instanceID := range instanceIDs {
+		work <- instanceID
+	}
+	close(work)
+	wg.Wait()
+	sort.Strings(leftover)
+	return leftover
+}
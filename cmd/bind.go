@@ -3,18 +3,17 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"strings"
+	"time"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/pborman/uuid"
 	"github.com/starkandwayne/eden/apiclient"
+	edenstore "github.com/starkandwayne/eden/store"
 )
 
 // BindOpts represents the 'bind' command
 type BindOpts struct {
-	Parameters      string `short:"P" long:"parameters" description:"parameters in json format. To use a file as input, prepend the filename with '@' (-P=@data.json)"`
+	Parameters string `short:"P" long:"parameters" description:"parameters in json format. To use a file as input, prepend the filename with '@' (-P=@data.json)"`
 }
 
 // Execute is callback from go-flags.Commander interface
@@ -29,59 +28,80 @@ func (c BindOpts) Execute(_ []string) (err error) {
 		bindingID = uuid.New()
 	}
 
-	broker := apiclient.NewOpenServiceBroker(
-		Opts.Broker.URLOpt,
-		Opts.Broker.ClientOpt,
-		Opts.Broker.ClientSecretOpt,
-		Opts.Broker.APIVersion,
-	)
+	broker, err := Opts.broker()
+	if err != nil {
+		return err
+	}
 
 	bindingName := fmt.Sprintf("%s-%s", instance.ServiceName, bindingID)
 
-	var parameters json.RawMessage
-	if len(c.Parameters) > 0 {
-		var input []byte
-		if strings.HasPrefix(c.Parameters, "@") {
-			input, err = ioutil.ReadFile(c.Parameters[1:])
-			if err != nil {
-				return errwrap.Wrapf("Could not read file: {{err}}", err)
-			}
-		} else {
-			input = []byte(c.Parameters)
-		}
-		if err := json.Unmarshal(input, &parameters); err != nil {
-			return errwrap.Wrapf("Could not unmarshal parameters: {{err}}", err)
-		}
+	parameters, err := parseParameters(c.Parameters)
+	if err != nil {
+		return err
 	}
 	bindingResp, err := broker.Bind(instance.ServiceID, instance.PlanID, instance.ID, bindingID, parameters)
+	if apiclient.IsDryRun(err) {
+		return nil
+	}
 	if err != nil {
 		return errwrap.Wrapf("Failed to bind to service instance {{err}}", err)
 	}
-	err = Opts.config().BindServiceInstance(instance.ID, bindingID, bindingName, bindingResp.Credentials)
+	err = Opts.config().BindServiceInstance(instance.ID, bindingID, bindingName, bindingResp.Credentials, bindingDetails(bindingResp))
 	if err != nil {
 		return errwrap.Wrapf("Failed to store binding {{err}}", err)
 	}
 
-	if Opts.JSON {
-		var out struct {
-			Instance    interface{} `json:"instance"`
-			Binding     interface{} `json:"binding"`
-			BindingID   string      `json:"binding_id"`
-			BindingName string      `json:"binding_name"`
-		}
-		out.Instance = instance
-		out.Binding = bindingResp
-		out.BindingID = bindingID
-		out.BindingName = bindingName
-		b, err := json.Marshal(out)
-		if err != nil {
-			return err
+	var out struct {
+		Instance    interface{} `json:"instance"`
+		Binding     interface{} `json:"binding"`
+		BindingID   string      `json:"binding_id"`
+		BindingName string      `json:"binding_name"`
+	}
+	out.Instance = instance
+	out.Binding = bindingResp
+	out.BindingID = bindingID
+	out.BindingName = bindingName
+	return render(out, func() error {
+		fmt.Println("Success")
+		fmt.Println("")
+		fmt.Printf("Run 'eden credentials -i %s -b %s' to see credentials\n", instance.Name, bindingName)
+		return nil
+	})
+}
+
+// bindingDetails converts the non-credential fields of a binding response for storage
+func bindingDetails(resp *apiclient.BindingResponse) edenstore.FSBindingDetails {
+	details := edenstore.FSBindingDetails{
+		SyslogDrainURL:  resp.SyslogDrainURL,
+		RouteServiceURL: resp.RouteServiceURL,
+	}
+	if len(resp.VolumeMounts) > 0 {
+		if b, err := json.Marshal(resp.VolumeMounts); err == nil {
+			details.VolumeMounts = string(b)
 		}
-		fmt.Printf("%s\n", string(b))
-		os.Exit(0)
 	}
-	fmt.Println("Success")
-	fmt.Println("")
-	fmt.Printf("Run 'eden credentials -i %s -b %s' to see credentials\n", instance.Name, bindingName)
-	return
+	for _, endpoint := range resp.Endpoints {
+		details.Endpoints = append(details.Endpoints, edenstore.FSEndpoint{
+			Host:     endpoint.Host,
+			Ports:    endpoint.Ports,
+			Protocol: endpoint.Protocol,
+		})
+	}
+	if resp.Metadata != nil {
+		details.ExpiresAt = parseBindingTime(resp.Metadata.ExpiresAt)
+		details.RenewBefore = parseBindingTime(resp.Metadata.RenewBefore)
+	}
+	return details
+}
+
+// parseBindingTime parses ISO 8601 binding metadata timestamps, ignoring invalid ones
+func parseBindingTime(value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil
+	}
+	return &t
 }
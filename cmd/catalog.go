@@ -1,12 +1,11 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 
 	"github.com/jhunt/go-table"
-	"github.com/starkandwayne/eden/apiclient"
+	"github.com/pivotal-cf/brokerapi"
 )
 
 // CatalogOpts represents the 'catalog' command
@@ -16,17 +15,14 @@ type CatalogOpts struct {
 
 // Execute is callback from go-flags.Commander interface
 func (c CatalogOpts) Execute(_ []string) (err error) {
-	broker := apiclient.NewOpenServiceBroker(
-		Opts.Broker.URLOpt,
-		Opts.Broker.ClientOpt,
-		Opts.Broker.ClientSecretOpt,
-		Opts.Broker.APIVersion,
-	)
+	broker, err := Opts.broker()
+	if err != nil {
+		return err
+	}
 
 	catalogResp, err := broker.Catalog()
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
-		os.Exit(1)
+		return err
 	}
 
 	if Opts.Catalog.Strict {
@@ -42,24 +38,20 @@ func (c CatalogOpts) Execute(_ []string) (err error) {
 		}
 
 		if len(errors) != 0 {
-			fmt.Fprintf(os.Stderr, "Catalog validation failed:\n")
+			message := "Catalog validation failed:"
 			for _, err := range errors {
-				fmt.Fprintf(os.Stderr, "  - %s\n", err)
+				message += fmt.Sprintf("\n  - %s", err)
 			}
-			os.Exit(1)
+			return fmt.Errorf("%s", message)
 		}
 	}
 
-	if Opts.JSON {
-		b, err := json.Marshal(catalogResp)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err.Error())
-			os.Exit(1)
-		}
-		fmt.Printf("%s\n", string(b))
-		os.Exit(0)
-	}
+	return render(catalogResp, func() error {
+		return c.printTable(catalogResp)
+	})
+}
 
+func (c CatalogOpts) printTable(catalogResp *brokerapi.CatalogResponse) error {
 	table := table.NewTable("Service", "Plan", "Free", "Description")
 
 	var serviceID string
@@ -91,5 +83,5 @@ func (c CatalogOpts) Execute(_ []string) (err error) {
 	}
 
 	table.Output(os.Stdout)
-	return
+	return nil
 }
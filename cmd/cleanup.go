@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jhunt/go-table"
+	"github.com/pivotal-cf/brokerapi"
+	edenstore "github.com/starkandwayne/eden/store"
+)
+
+// CleanupOpts represents the 'cleanup' command
+type CleanupOpts struct {
+	OlderThan time.Duration `long:"older-than" description:"Only instances created longer ago than this, e.g. 24h"`
+	Selector  []string      `short:"l" long:"label" description:"Only instances matching this label selector, e.g. -l ci=true,owner!=bob"`
+	Broker    string        `long:"broker" description:"All instances of the target broker (URL or host name, which must match --url)"`
+	Parallel  int           `long:"parallel" description:"Maximum number of instances to remove concurrently" default:"5"`
+}
+
+// cleanupResult is the outcome of removing one service instance
+type cleanupResult struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	ServiceName string `json:"service_name"`
+	PlanName    string `json:"plan_name"`
+	BrokerURL   string `json:"broker_url"`
+	Age         string `json:"age"`
+	Bindings    int    `json:"bindings"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+
+	instance *edenstore.FSServiceInstance
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c CleanupOpts) Execute(_ []string) (err error) {
+	if c.OlderThan == 0 && len(c.Selector) == 0 && c.Broker == "" {
+		return fmt.Errorf("cleanup command requires at least one of --older-than, --label or --broker")
+	}
+	if c.Broker != "" && !matchesBroker(Opts.Broker.URLOpt, c.Broker) {
+		return fmt.Errorf("cleanup --broker '%s' is not the target broker %s; target it with --url to use its credentials", c.Broker, Opts.Broker.URLOpt)
+	}
+	instances, err := selectInstances(c.Selector)
+	if err != nil {
+		return err
+	}
+
+	results := []*cleanupResult{}
+	for _, inst := range instances {
+		if !c.matches(inst) {
+			continue
+		}
+		results = append(results, &cleanupResult{
+			ID:          inst.ID,
+			Name:        inst.Name,
+			ServiceName: inst.ServiceName,
+			PlanName:    inst.PlanName,
+			BrokerURL:   inst.BrokerURL,
+			Age:         time.Since(inst.CreatedAt).Round(time.Minute).String(),
+			Bindings:    len(inst.Bindings),
+			Status:      "planned",
+			instance:    inst,
+		})
+	}
+
+	removable := []*cleanupResult{}
+	for _, result := range results {
+		if result.instance.Protected {
+			result.Status = "protected"
+			continue
+		}
+		removable = append(removable, result)
+	}
+	if Opts.DryRun == "" {
+		c.removeAll(removable)
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Status == "failed" {
+			failed++
+		}
+	}
+	err = render(results, func() error {
+		if len(results) == 0 {
+			fmt.Println("cleanup: no matching service instances")
+			return nil
+		}
+		table := table.NewTable("Name", "Service/Plan", "Age", "Bindings", "Broker URL", "Status")
+		for _, result := range results {
+			status := result.Status
+			if result.Error != "" {
+				status = fmt.Sprintf("%s: %s", result.Status, result.Error)
+			}
+			table.Row(nil, result.Name, result.ServiceName+"/"+result.PlanName, result.Age,
+				fmt.Sprintf("%d", result.Bindings), result.BrokerURL, status)
+		}
+		table.Output(os.Stdout)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("cleanup: %d of %d instances could not be removed", failed, len(results))
+	}
+	return nil
+}
+
+// matches returns true if the instance is of the target broker and satisfies
+// the --older-than filter
+func (c CleanupOpts) matches(inst *edenstore.FSServiceInstance) bool {
+	if !Opts.isTargetBroker(inst.BrokerURL) {
+		return false
+	}
+	if c.OlderThan > 0 && time.Since(inst.CreatedAt) < c.OlderThan {
+		return false
+	}
+	return true
+}
+
+// matchesBroker compares a broker URL with either a URL or a host name
+func matchesBroker(brokerURL, nameOrURL string) bool {
+	if strings.TrimSuffix(brokerURL, "/") == strings.TrimSuffix(nameOrURL, "/") {
+		return true
+	}
+	parsed, err := url.Parse(brokerURL)
+	if err != nil {
+		return false
+	}
+	return parsed.Host == nameOrURL || parsed.Hostname() == nameOrURL
+}
+
+// removeAll unbinds and deprovisions instances concurrently, up to --parallel
+// at a time, recording the outcome of each
+func (c CleanupOpts) removeAll(results []*cleanupResult) {
+	parallel := c.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	work := make(chan *cleanupResult)
+	wg := sync.WaitGroup{}
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for result := range work {
+				progress("cleanup: %s/%s - name: %s\n", result.ServiceName, result.PlanName, result.Name)
+				err := c.remove(result.instance)
+				if err != nil {
+					result.Status = "failed"
+					result.Error = err.Error()
+					progress("cleanup: %s failed: %s\n", result.Name, err)
+					continue
+				}
+				result.Status = "removed"
+			}
+		}()
+	}
+	for _, result := range results {
+		work <- result
+	}
+	close(work)
+	wg.Wait()
+}
+
+// remove unbinds all bindings of an instance, then deprovisions it
+func (c CleanupOpts) remove(inst *edenstore.FSServiceInstance) error {
+	broker, err := Opts.brokerAt(inst.BrokerURL)
+	if err != nil {
+		return err
+	}
+
+	for _, binding := range inst.Bindings {
+		if err = broker.Unbind(inst.ServiceID, inst.PlanID, inst.ID, binding.ID); err != nil {
+			return fmt.Errorf("unbind %s: %s", binding.Name, err)
+		}
+		if err = Opts.config().UnbindServiceInstance(inst.ID, binding.ID); err != nil {
+			return err
+		}
+	}
+
+	resp, isAsync, err := broker.Deprovision(inst.ServiceID, inst.PlanID, inst.ID)
+	if err != nil {
+		return err
+	}
+	if isAsync {
+		lastOpResp, err := waitForLastOperation(broker, "deprovision", inst.ServiceID, inst.PlanID, inst.ID, resp.OperationData,
+			func(lastOpResp *brokerapi.LastOperationResponse) {
+				progress("cleanup: %s - %s %s\n", inst.Name, lastOpResp.State, lastOpResp.Description)
+			})
+		if err != nil {
+			return err
+		}
+		if err = lastOperationError(lastOpResp); err != nil {
+			return err
+		}
+	}
+	return Opts.config().DeprovisionServiceInstance(inst.ID)
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// isTerminal reports whether the file (e.g. os.Stdin) is an interactive terminal
+func isTerminal(file *os.File) bool {
+	stat, err := file.Stat()
+	if err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	// /dev/null is also a character device
+	devNull, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(stat, devNull)
+}
+
+// confirm asks a yes/no question on the terminal; it is false if stdin is not a terminal
+func confirm(question string) bool {
+	if !isTerminal(os.Stdin) {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
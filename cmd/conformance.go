@@ -0,0 +1,532 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/pborman/uuid"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/starkandwayne/eden/apiclient"
+)
+
+// ConformanceOpts represents the 'conformance' command
+type ConformanceOpts struct {
+	ServiceNameOrID string        `short:"s" long:"service-name" description:"Service name/ID to test (default: first bindable service)"`
+	PlanNameOrID    string        `short:"p" long:"plan-name" description:"Plan name/ID to test (default: first)"`
+	Parameters      string        `short:"P" long:"parameters" description:"parameters in json format. To use a file as input, prepend the filename with '@' (-P=@data.json)"`
+	JUnit           string        `long:"junit" description:"Write a JUnit XML report to file"`
+	Timeout         time.Duration `long:"timeout" description:"Maximum time to wait for each async operation" default:"10m"`
+}
+
+// conformanceRun drives a broker through the OSB API with raw requests, so
+// that it can also send requests that eden would not normally send
+type conformanceRun struct {
+	opts       ConformanceOpts
+	url        string
+	apiVersion string
+	auth       apiclient.Authenticator
+	client     *http.Client
+	cases      []*testCase
+	parameters json.RawMessage
+
+	service     *brokerapi.Service
+	plan        *brokerapi.ServicePlan
+	otherPlan   *brokerapi.ServicePlan
+	instanceID  string
+	provisioned bool
+}
+
+// skipTest is returned by a check that does not apply to the broker
+type skipTest string
+
+func (s skipTest) Error() string {
+	return string(s)
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c ConformanceOpts) Execute(_ []string) (err error) {
+	auth, err := Opts.authenticator()
+	if err != nil {
+		return err
+	}
+	parameters, err := parseParameters(c.Parameters)
+	if err != nil {
+		return err
+	}
+	broker, err := Opts.broker()
+	if err != nil {
+		return err
+	}
+	run := &conformanceRun{
+		opts:       c,
+		url:        strings.TrimSuffix(Opts.Broker.URLOpt, "/"),
+		apiVersion: broker.APIVersion(),
+		auth:       auth,
+		client:     &http.Client{Transport: Opts.brokerTransport()},
+		parameters: parameters,
+		instanceID: uuid.New(),
+	}
+	run.runAll()
+
+	if c.JUnit != "" {
+		if err = writeJUnit(c.JUnit, run.cases); err != nil {
+			return err
+		}
+	}
+	err = render(run.cases, func() error {
+		printTestCases(run.cases)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if failed := failedTestCases(run.cases); failed > 0 {
+		return fmt.Errorf("conformance: %d of %d checks failed", failed, len(run.cases))
+	}
+	return nil
+}
+
+func (run *conformanceRun) runAll() {
+	defer run.cleanup()
+
+	if !run.check("catalog", run.checkCatalog) {
+		for _, name := range []string{"api-version-required", "bad-auth", "invalid-plan", "async-required",
+			"provision", "provision-identical", "provision-conflict", "bind", "bind-identical",
+			"unbind", "unbind-gone", "deprovision", "deprovision-gone"} {
+			run.record(name, testSkipped, 0, "catalog is not available")
+		}
+		return
+	}
+	run.check("api-version-required", run.checkAPIVersionRequired)
+	run.check("bad-auth", run.checkBadAuth)
+	run.check("invalid-plan", run.checkInvalidPlan)
+	run.check("async-required", run.checkAsyncRequired)
+
+	provisioned := run.check("provision", run.checkProvision)
+	if provisioned {
+		run.check("provision-identical", run.checkProvisionIdentical)
+		run.check("provision-conflict", run.checkProvisionConflict)
+	} else {
+		run.record("provision-identical", testSkipped, 0, "provision failed")
+		run.record("provision-conflict", testSkipped, 0, "provision failed")
+	}
+
+	bindingID := uuid.New()
+	bound := false
+	if provisioned {
+		bound = run.check("bind", func() error { return run.checkBind(bindingID, http.StatusCreated) })
+	} else {
+		run.record("bind", testSkipped, 0, "provision failed")
+	}
+	if bound {
+		run.check("bind-identical", func() error { return run.checkBind(bindingID, http.StatusOK) })
+		run.check("unbind", func() error { return run.checkUnbind(bindingID, http.StatusOK) })
+		run.check("unbind-gone", func() error { return run.checkUnbind(bindingID, http.StatusGone) })
+	} else {
+		for _, name := range []string{"bind-identical", "unbind", "unbind-gone"} {
+			run.record(name, testSkipped, 0, "bind failed")
+		}
+	}
+
+	if provisioned {
+		if run.check("deprovision", run.checkDeprovision) {
+			run.check("deprovision-gone", run.checkDeprovisionGone)
+		} else {
+			run.record("deprovision-gone", testSkipped, 0, "deprovision failed")
+		}
+	} else {
+		run.record("deprovision", testSkipped, 0, "provision failed")
+		run.record("deprovision-gone", testSkipped, 0, "provision failed")
+	}
+}
+
+// check runs a single check, records its outcome, and returns true if it passed
+func (run *conformanceRun) check(name string, fn func() error) bool {
+	progress("conformance: %s\n", name)
+	started := time.Now()
+	err := fn()
+	elapsed := time.Since(started)
+	switch err := err.(type) {
+	case nil:
+		run.record(name, testPassed, elapsed, "")
+		return true
+	case skipTest:
+		run.record(name, testSkipped, elapsed, err.Error())
+	default:
+		run.record(name, testFailed, elapsed, err.Error())
+	}
+	return false
+}
+
+func (run *conformanceRun) record(name, status string, elapsed time.Duration, message string) {
+	run.cases = append(run.cases, &testCase{Suite: "conformance", Name: name, Status: status, Duration: elapsed, Message: message})
+}
+
+// request sends a raw request to the broker; apiVersion and auth may be
+// empty/nil to leave out the X-Broker-Api-Version and Authorization headers
+func (run *conformanceRun) request(method, path string, body interface{}, apiVersion string, auth apiclient.Authenticator) (int, []byte, error) {
+	var reqBody *bytes.Buffer
+	if body != nil {
+		reqBody = &bytes.Buffer{}
+		if err := json.NewEncoder(reqBody).Encode(body); err != nil {
+			return 0, nil, errwrap.Wrapf("Cannot encode request body: {{err}}", err)
+		}
+	}
+	var req *http.Request
+	var err error
+	if reqBody != nil {
+		req, err = http.NewRequest(method, run.url+path, reqBody)
+	} else {
+		req, err = http.NewRequest(method, run.url+path, nil)
+	}
+	if err != nil {
+		return 0, nil, errwrap.Wrapf("Cannot construct HTTP request: {{err}}", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiVersion != "" {
+		req.Header.Set("X-Broker-Api-Version", apiVersion)
+	}
+	if auth != nil {
+		if err = auth.Authenticate(req); err != nil {
+			return 0, nil, errwrap.Wrapf("Failed to authenticate HTTP request: {{err}}", err)
+		}
+	}
+
+	resp, err := run.client.Do(req)
+	if err != nil {
+		return 0, nil, errwrap.Wrapf("Failed doing HTTP request: {{err}}", err)
+	}
+	defer resp.Body.Close()
+	resBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, errwrap.Wrapf("Failed reading HTTP response body: {{err}}", err)
+	}
+	return resp.StatusCode, resBody, nil
+}
+
+// brokerRequest sends a request with the usual API version and authentication
+func (run *conformanceRun) brokerRequest(method, path string, body interface{}) (int, []byte, error) {
+	return run.request(method, path, body, run.apiVersion, run.auth)
+}
+
+// expectStatus returns an error unless the status is one of those expected
+func expectStatus(status int, body []byte, expected ...int) error {
+	for _, code := range expected {
+		if status == code {
+			return nil
+		}
+	}
+	codes := []string{}
+	for _, code := range expected {
+		codes = append(codes, fmt.Sprintf("%d", code))
+	}
+	message := fmt.Sprintf("expected status %s, got %d", strings.Join(codes, " or "), status)
+	// only show the body of error responses, as others may contain credentials
+	if status >= 400 {
+		errorResp := brokerapi.ErrorResponse{}
+		if json.Unmarshal(body, &errorResp) == nil && errorResp.Description != "" {
+			return fmt.Errorf("%s: %s", message, errorResp.Description)
+		}
+		if detail := strings.TrimSpace(string(body)); detail != "" {
+			if len(detail) > 200 {
+				detail = detail[:200] + "..."
+			}
+			return fmt.Errorf("%s: %s", message, detail)
+		}
+	}
+	return fmt.Errorf("%s", message)
+}
+
+func (run *conformanceRun) checkCatalog() error {
+	status, body, err := run.brokerRequest("GET", "/v2/catalog", nil)
+	if err != nil {
+		return err
+	}
+	if err = expectStatus(status, body, http.StatusOK); err != nil {
+		return err
+	}
+	catalog := brokerapi.CatalogResponse{}
+	if err = json.Unmarshal(body, &catalog); err != nil {
+		return fmt.Errorf("invalid catalog: %s", err)
+	}
+	if len(catalog.Services) == 0 {
+		return fmt.Errorf("catalog has no services")
+	}
+	for _, service := range catalog.Services {
+		if service.ID == "" || service.Name == "" || service.Description == "" {
+			return fmt.Errorf("service '%s' (%s) requires an id, name and description", service.Name, service.ID)
+		}
+		if len(service.Plans) == 0 {
+			return fmt.Errorf("service '%s' has no plans", service.Name)
+		}
+		for _, plan := range service.Plans {
+			if plan.ID == "" || plan.Name == "" || plan.Description == "" {
+				return fmt.Errorf("plan '%s' (%s) of service '%s' requires an id, name and description", plan.Name, plan.ID, service.Name)
+			}
+		}
+	}
+
+	for i, service := range catalog.Services {
+		if run.opts.ServiceNameOrID == "" && service.Bindable ||
+			run.opts.ServiceNameOrID == service.Name || run.opts.ServiceNameOrID == service.ID {
+			run.service = &catalog.Services[i]
+			break
+		}
+	}
+	if run.service == nil && run.opts.ServiceNameOrID == "" {
+		run.service = &catalog.Services[0]
+	}
+	if run.service == nil {
+		return fmt.Errorf("service '%s' is not in the catalog", run.opts.ServiceNameOrID)
+	}
+	for i, plan := range run.service.Plans {
+		if run.plan == nil && (run.opts.PlanNameOrID == "" || run.opts.PlanNameOrID == plan.Name || run.opts.PlanNameOrID == plan.ID) {
+			run.plan = &run.service.Plans[i]
+		} else if run.otherPlan == nil {
+			run.otherPlan = &run.service.Plans[i]
+		}
+	}
+	if run.plan == nil {
+		return fmt.Errorf("plan '%s' is not in service '%s'", run.opts.PlanNameOrID, run.service.Name)
+	}
+	progress("conformance: using %s/%s\n", run.service.Name, run.plan.Name)
+	return nil
+}
+
+func (run *conformanceRun) checkAPIVersionRequired() error {
+	status, body, err := run.request("GET", "/v2/catalog", nil, "", run.auth)
+	if err != nil {
+		return err
+	}
+	return expectStatus(status, body, http.StatusPreconditionFailed)
+}
+
+func (run *conformanceRun) checkBadAuth() error {
+	var auth apiclient.Authenticator = apiclient.BearerToken{Token: "eden-conformance-" + uuid.New()}
+	if Opts.brokerAuth().Type == "basic" {
+		auth = apiclient.BasicAuth{Username: "eden-conformance", Password: uuid.New()}
+	}
+	status, body, err := run.request("GET", "/v2/catalog", nil, run.apiVersion, auth)
+	if err != nil {
+		return err
+	}
+	return expectStatus(status, body, http.StatusUnauthorized)
+}
+
+func (run *conformanceRun) provisionDetails(planID string, parameters json.RawMessage) brokerapi.ProvisionDetails {
+	return brokerapi.ProvisionDetails{
+		ServiceID:        run.service.ID,
+		PlanID:           planID,
+		OrganizationGUID: "eden-conformance-org",
+		SpaceGUID:        "eden-conformance-space",
+		RawParameters:    parameters,
+	}
+}
+
+func (run *conformanceRun) checkInvalidPlan() error {
+	instanceID := uuid.New()
+	path := fmt.Sprintf("/v2/service_instances/%s?accepts_incomplete=true", instanceID)
+	status, body, err := run.brokerRequest("PUT", path, run.provisionDetails("eden-invalid-plan-"+uuid.New(), run.parameters))
+	if err != nil {
+		return err
+	}
+	if status == http.StatusCreated || status == http.StatusAccepted {
+		run.deleteInstance(instanceID, "eden-invalid-plan")
+	}
+	return expectStatus(status, body, http.StatusBadRequest)
+}
+
+func (run *conformanceRun) checkAsyncRequired() error {
+	instanceID := uuid.New()
+	path := fmt.Sprintf("/v2/service_instances/%s?accepts_incomplete=false", instanceID)
+	status, body, err := run.brokerRequest("PUT", path, run.provisionDetails(run.plan.ID, run.parameters))
+	if err != nil {
+		return err
+	}
+	switch status {
+	case http.StatusCreated:
+		run.deleteInstance(instanceID, run.plan.ID)
+		return skipTest("broker provisions synchronously")
+	case http.StatusUnprocessableEntity:
+		errorResp := brokerapi.ErrorResponse{}
+		json.Unmarshal(body, &errorResp)
+		if errorResp.Error != "AsyncRequired" {
+			return fmt.Errorf("expected error AsyncRequired, got '%s'", errorResp.Error)
+		}
+		return nil
+	case http.StatusAccepted:
+		run.deleteInstance(instanceID, run.plan.ID)
+		return fmt.Errorf("broker provisioned asynchronously although accepts_incomplete=false")
+	}
+	return expectStatus(status, body, http.StatusCreated, http.StatusUnprocessableEntity)
+}
+
+func (run *conformanceRun) checkProvision() error {
+	path := fmt.Sprintf("/v2/service_instances/%s?accepts_incomplete=true", run.instanceID)
+	status, body, err := run.brokerRequest("PUT", path, run.provisionDetails(run.plan.ID, run.parameters))
+	if err != nil {
+		return err
+	}
+	if status == http.StatusCreated || status == http.StatusAccepted || status == http.StatusOK {
+		run.provisioned = true
+	}
+	if err = expectStatus(status, body, http.StatusCreated, http.StatusAccepted); err != nil {
+		return err
+	}
+	if status == http.StatusAccepted {
+		return run.waitForOperation(body, false)
+	}
+	return nil
+}
+
+func (run *conformanceRun) checkProvisionIdentical() error {
+	path := fmt.Sprintf("/v2/service_instances/%s?accepts_incomplete=true", run.instanceID)
+	status, body, err := run.brokerRequest("PUT", path, run.provisionDetails(run.plan.ID, run.parameters))
+	if err != nil {
+		return err
+	}
+	return expectStatus(status, body, http.StatusOK)
+}
+
+func (run *conformanceRun) checkProvisionConflict() error {
+	if run.otherPlan == nil {
+		return skipTest("service has a single plan")
+	}
+	path := fmt.Sprintf("/v2/service_instances/%s?accepts_incomplete=true", run.instanceID)
+	status, body, err := run.brokerRequest("PUT", path, run.provisionDetails(run.otherPlan.ID, run.parameters))
+	if err != nil {
+		return err
+	}
+	return expectStatus(status, body, http.StatusConflict)
+}
+
+func (run *conformanceRun) checkBind(bindingID string, expected int) error {
+	if !run.service.Bindable {
+		return skipTest("service is not bindable")
+	}
+	path := fmt.Sprintf("/v2/service_instances/%s/service_bindings/%s", run.instanceID, bindingID)
+	details := brokerapi.BindDetails{
+		ServiceID: run.service.ID,
+		PlanID:    run.plan.ID,
+		AppGUID:   "eden-conformance-app",
+	}
+	status, body, err := run.brokerRequest("PUT", path, details)
+	if err != nil {
+		return err
+	}
+	if err = expectStatus(status, body, expected); err != nil {
+		return err
+	}
+	binding := apiclient.BindingResponse{}
+	if err = json.Unmarshal(body, &binding); err != nil {
+		return fmt.Errorf("invalid binding response: %s", err)
+	}
+	return nil
+}
+
+func (run *conformanceRun) checkUnbind(bindingID string, expected int) error {
+	if !run.service.Bindable {
+		return skipTest("service is not bindable")
+	}
+	path := fmt.Sprintf("/v2/service_instances/%s/service_bindings/%s?service_id=%s&plan_id=%s",
+		run.instanceID, bindingID, run.service.ID, run.plan.ID)
+	status, body, err := run.brokerRequest("DELETE", path, nil)
+	if err != nil {
+		return err
+	}
+	return expectStatus(status, body, expected)
+}
+
+func (run *conformanceRun) checkDeprovision() error {
+	path := fmt.Sprintf("/v2/service_instances/%s?service_id=%s&plan_id=%s&accepts_incomplete=true",
+		run.instanceID, run.service.ID, run.plan.ID)
+	status, body, err := run.brokerRequest("DELETE", path, nil)
+	if err != nil {
+		return err
+	}
+	if err = expectStatus(status, body, http.StatusOK, http.StatusAccepted); err != nil {
+		return err
+	}
+	if status == http.StatusAccepted {
+		if err = run.waitForOperation(body, true); err != nil {
+			return err
+		}
+	}
+	run.provisioned = false
+	return nil
+}
+
+func (run *conformanceRun) checkDeprovisionGone() error {
+	path := fmt.Sprintf("/v2/service_instances/%s?service_id=%s&plan_id=%s&accepts_incomplete=true",
+		run.instanceID, run.service.ID, run.plan.ID)
+	status, body, err := run.brokerRequest("DELETE", path, nil)
+	if err != nil {
+		return err
+	}
+	return expectStatus(status, body, http.StatusGone)
+}
+
+// waitForOperation polls last_operation for the operation in an async
+// response; for deprovisioning, 410 Gone means it has succeeded
+func (run *conformanceRun) waitForOperation(asyncBody []byte, deprovisioning bool) error {
+	async := struct {
+		Operation string `json:"operation"`
+	}{}
+	json.Unmarshal(asyncBody, &async)
+	path := fmt.Sprintf("/v2/service_instances/%s/last_operation?service_id=%s&plan_id=%s",
+		run.instanceID, run.service.ID, run.plan.ID)
+	if async.Operation != "" {
+		path += "&operation=" + async.Operation
+	}
+
+	deadline := time.Now().Add(run.opts.Timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(pollInterval)
+		status, body, err := run.brokerRequest("GET", path, nil)
+		if err != nil {
+			return err
+		}
+		if deprovisioning && status == http.StatusGone {
+			return nil
+		}
+		if err = expectStatus(status, body, http.StatusOK); err != nil {
+			return fmt.Errorf("last_operation: %s", err)
+		}
+		lastOpResp := brokerapi.LastOperationResponse{}
+		if err = json.Unmarshal(body, &lastOpResp); err != nil {
+			return fmt.Errorf("invalid last_operation response: %s", err)
+		}
+		switch lastOpResp.State {
+		case brokerapi.Succeeded:
+			return nil
+		case brokerapi.Failed:
+			return fmt.Errorf("operation failed: %s", lastOpResp.Description)
+		case brokerapi.InProgress:
+			progress("conformance: %s - %s\n", lastOpResp.State, lastOpResp.Description)
+		default:
+			return fmt.Errorf("last_operation has invalid state '%s'", lastOpResp.State)
+		}
+	}
+	return fmt.Errorf("operation did not finish within %s", run.opts.Timeout)
+}
+
+// deleteInstance removes an instance created by a check, ignoring failures
+func (run *conformanceRun) deleteInstance(instanceID, planID string) {
+	path := fmt.Sprintf("/v2/service_instances/%s?service_id=%s&plan_id=%s&accepts_incomplete=true",
+		instanceID, run.service.ID, planID)
+	run.brokerRequest("DELETE", path, nil)
+}
+
+// cleanup deprovisions the test instance if a check failed before doing so
+func (run *conformanceRun) cleanup() {
+	if run.provisioned {
+		progress("conformance: cleaning up instance %s\n", run.instanceID)
+		run.deleteInstance(run.instanceID, run.plan.ID)
+	}
+}
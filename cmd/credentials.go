@@ -3,20 +3,70 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/errwrap"
+	"github.com/jhunt/go-table"
+	"github.com/starkandwayne/eden/apiclient"
+	edenstore "github.com/starkandwayne/eden/store"
 )
 
 // CredentialsOpts represents the 'credentials' command
 type CredentialsOpts struct {
-	BindingID string `short:"b" long:"bind" description:"Binding to display"`
-	Attribute string `short:"a" long:"attribute" description:"Only diplay a single attribute from credentials"`
+	BindingID string   `short:"b" long:"bind" description:"Binding to display"`
+	Attribute string   `short:"a" long:"attribute" description:"Only display a single attribute from credentials; supports nested paths (admin.password, hosts.0) and JSONPath/jq ($..password, .hosts[], hosts[0:2], endpoints[?(@.port > 5000)])"`
+	Format    string   `short:"f" long:"format" description:"Export credentials for use elsewhere" choice:"env" choice:"export" choice:"dotenv" choice:"netrc" choice:"k8s-secret" choice:"vcap"`
+	Prefix    string   `long:"prefix" description:"Prefix for variable names with --format env|export|dotenv"`
+	Selector  []string `short:"l" long:"label" description:"Select instances by label selector instead of --instance, e.g. -l env=staging"`
+
+	ExpiringWithin time.Duration `long:"expiring-within" description:"List bindings that expire or are due for renewal within this duration, e.g. 24h"`
+}
+
+// expiringBinding is an item of 'credentials --expiring-within' output
+type expiringBinding struct {
+	InstanceID   string     `json:"instance_id"`
+	InstanceName string     `json:"instance_name"`
+	BindingID    string     `json:"binding_id"`
+	BindingName  string     `json:"binding_name"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	RenewBefore  *time.Time `json:"renew_before,omitempty"`
 }
 
 // Execute is callback from go-flags.Commander interface
 func (c CredentialsOpts) Execute(_ []string) (err error) {
+	if c.ExpiringWithin > 0 {
+		return c.showExpiring()
+	}
+	if c.Format == "vcap" {
+		// VCAP_SERVICES combines the bindings of all instances
+		instances, err := selectInstances(c.Selector)
+		if err != nil {
+			return err
+		}
+		vcap, err := vcapServices(instances)
+		if err != nil {
+			return err
+		}
+		fmt.Println(vcap)
+		return nil
+	}
+	if c.Format != "" && c.Attribute != "" {
+		return fmt.Errorf("credentials --format and --attribute cannot be used together")
+	}
+
 	instanceNameOrID := Opts.Instance.NameOrID
+	if instanceNameOrID == "" && len(c.Selector) > 0 {
+		instances, err := selectInstances(c.Selector)
+		if err != nil {
+			return err
+		}
+		if len(instances) != 1 {
+			return fmt.Errorf("credentials --label matches %d instances, but requires exactly one (or use --format vcap)", len(instances))
+		}
+		instanceNameOrID = instances[0].ID
+	}
 	if instanceNameOrID == "" {
 		return fmt.Errorf("credentials command requires --instance [NAME|GUID], or $SB_INSTANCE")
 	}
@@ -25,21 +75,26 @@ func (c CredentialsOpts) Execute(_ []string) (err error) {
 		return fmt.Errorf("credentials --instance '%s' was not found", instanceNameOrID)
 	}
 	if len(inst.Bindings) > 0 {
-		binding_idx := inst.FindServiceBinding(c.BindingID)
-		if binding_idx == -1 {
-			return fmt.Errorf("binding '%s' was not found for instance '%s'", c.BindingID, instanceNameOrID)
+		binding, err := selectBinding(inst, c.BindingID)
+		if err != nil {
+			return err
 		}
-		binding := inst.Bindings[binding_idx]
 
 		// convert binding.Credentials into nested map[string]map[string]interface{}
 		credentialsJSON, err := binding.CredentialsJSON()
 		if err != nil {
 			return err
 		}
+		if c.Format != "" {
+			return c.exportBinding(inst.Name, credentialsJSON)
+		}
 		if err := c.displayBinding(credentialsJSON, c.Attribute); err != nil {
 			return err
 		}
 	} else {
+		if !Opts.tableOutput() {
+			return fmt.Errorf("credentials --instance '%s' has no bindings", instanceNameOrID)
+		}
 		fmt.Println("No bindings.")
 	}
 	return
@@ -47,21 +102,137 @@ func (c CredentialsOpts) Execute(_ []string) (err error) {
 
 func (c CredentialsOpts) displayBinding(credentials map[string]interface{}, attribute string) error {
 	if attribute == "" {
-		b, err := json.MarshalIndent(credentials, "", "  ")
-		if err != nil {
-			return errwrap.Wrapf("Could not marshal credentials: {{err}}", err)
+		return render(credentials, func() error {
+			b, err := json.MarshalIndent(credentials, "", "  ")
+			if err != nil {
+				return errwrap.Wrapf("Could not marshal credentials: {{err}}", err)
+			}
+			fmt.Printf("%s\n", string(b))
+			return nil
+		})
+	}
+	values, err := lookupAttribute(credentials, attribute)
+	if err != nil {
+		return fmt.Errorf("credentials --attribute '%s' is invalid: %s", attribute, err)
+	}
+	if len(values) == 0 {
+		if suggestions := suggestAttributes(credentials, attribute); len(suggestions) > 0 {
+			return fmt.Errorf("credentials --attribute '%s' was not found; did you mean: %s", attribute, strings.Join(suggestions, ", "))
+		}
+		return fmt.Errorf("credentials --attribute '%s' was not found; try: %s", attribute, strings.Join(attributePaths(credentials), ", "))
+	}
+
+	var result interface{} = values
+	if len(values) == 1 {
+		result = values[0]
+	}
+	return render(result, func() error {
+		for _, val := range values {
+			switch val.(type) {
+			case map[string]interface{}, []interface{}:
+				b, err := json.MarshalIndent(val, "", "  ")
+				if err != nil {
+					return errwrap.Wrapf("Could not marshal credentials: {{err}}", err)
+				}
+				fmt.Printf("%s\n", string(b))
+			default:
+				fmt.Println(scalarString(val))
+			}
 		}
-		fmt.Printf("%s\n", string(b))
 		return nil
+	})
+}
+
+func (c CredentialsOpts) showExpiring() error {
+	deadline := time.Now().Add(c.ExpiringWithin)
+	expiring := []expiringBinding{}
+	instances, err := selectInstances(c.Selector)
+	if err != nil {
+		return err
+	}
+	for _, inst := range instances {
+		if Opts.Instance.NameOrID != "" && Opts.Instance.NameOrID != inst.Name && Opts.Instance.NameOrID != inst.ID {
+			continue
+		}
+		for _, binding := range inst.Bindings {
+			if binding.RenewalDue(deadline) {
+				expiring = append(expiring, expiringBinding{
+					InstanceID:   inst.ID,
+					InstanceName: inst.Name,
+					BindingID:    binding.ID,
+					BindingName:  binding.Name,
+					ExpiresAt:    binding.ExpiresAt,
+					RenewBefore:  binding.RenewBefore,
+				})
+			}
+		}
 	}
-	if val, ok := credentials[attribute]; ok {
-		fmt.Printf("%v\n", val)
+
+	return render(expiring, func() error {
+		if len(expiring) == 0 {
+			fmt.Printf("No bindings expire within %s.\n", c.ExpiringWithin)
+			return nil
+		}
+		formatTime := func(t *time.Time) string {
+			if t == nil {
+				return "n/a"
+			}
+			return t.Local().Format(time.RFC3339)
+		}
+		table := table.NewTable("Instance", "Binding", "Expires", "Renew Before")
+		for _, item := range expiring {
+			table.Row(nil, item.InstanceName, item.BindingName, formatTime(item.ExpiresAt), formatTime(item.RenewBefore))
+		}
+		table.Output(os.Stdout)
+		return nil
+	})
+}
+
+func (c CredentialsOpts) exportBinding(name string, credentials map[string]interface{}) error {
+	switch c.Format {
+	case "k8s-secret":
+		secret, err := k8sSecret(name, credentials)
+		if err != nil {
+			return err
+		}
+		fmt.Print(secret)
+		return nil
+	case "netrc":
+		entry, err := netrcEntry(credentials)
+		if err != nil {
+			return err
+		}
+		fmt.Print(entry)
 		return nil
 	}
-	attributes := make([]string, 0, len(credentials))
-	for key := range credentials {
-		attributes = append(attributes, key)
+	names, values, err := envVars(credentials, c.Prefix)
+	if err != nil {
+		return err
 	}
+	for _, name := range names {
+		switch c.Format {
+		case "export":
+			fmt.Printf("export %s=%s\n", name, apiclient.ShellQuote(values[name]))
+		case "dotenv":
+			fmt.Printf("%s=%s\n", name, dotenvQuote(values[name]))
+		default:
+			fmt.Printf("%s=%s\n", name, envQuote(values[name]))
+		}
+	}
+	return nil
+}
 
-	return fmt.Errorf("credentials --attribute key was unknown; try: %s", strings.Join(attributes, ", "))
+// selectBinding finds a binding of the instance by name/ID, defaulting to the most recent binding
+func selectBinding(inst edenstore.FSServiceInstance, bindingNameOrID string) (*edenstore.FSServiceBinding, error) {
+	if len(inst.Bindings) == 0 {
+		return nil, fmt.Errorf("instance '%s' has no bindings", inst.Name)
+	}
+	if bindingNameOrID == "" {
+		return &inst.Bindings[len(inst.Bindings)-1], nil
+	}
+	bindingIdx := inst.FindServiceBinding(bindingNameOrID)
+	if bindingIdx == -1 {
+		return nil, fmt.Errorf("binding '%s' was not found for instance '%s'", bindingNameOrID, inst.Name)
+	}
+	return &inst.Bindings[bindingIdx], nil
 }
@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	edenstore "github.com/starkandwayne/eden/store"
+	"gopkg.in/yaml.v2"
+)
+
+// flattenCredentials converts nested credentials into a flat map, joining
+// nested keys and array indices with sep, e.g. admin.password or hosts.0
+func flattenCredentials(credentials interface{}, prefix, sep string) map[string]string {
+	flat := map[string]string{}
+	flattenInto(flat, credentials, prefix, sep)
+	return flat
+}
+
+func flattenInto(flat map[string]string, value interface{}, key, sep string) {
+	join := func(child string) string {
+		if key == "" {
+			return child
+		}
+		return key + sep + child
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for childKey, child := range v {
+			flattenInto(flat, child, join(childKey), sep)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flattenInto(flat, child, join(strconv.Itoa(i)), sep)
+		}
+	default:
+		flat[key] = scalarString(v)
+	}
+}
+
+// scalarString formats a JSON scalar without exponents or "<nil>"
+func scalarString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+var nonEnvChars = regexp.MustCompile(`[^A-Z0-9_]+`)
+
+// envName converts a flattened credentials key into a valid environment variable name
+func envName(key string) string {
+	name := nonEnvChars.ReplaceAllString(strings.ToUpper(key), "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// envVars flattens credentials into sorted NAME=value pairs. Keys that map to
+// the same name, e.g. admin.password and admin_password, are an error.
+func envVars(credentials interface{}, prefix string) (names []string, values map[string]string, err error) {
+	values = map[string]string{}
+	keys := map[string]string{}
+	for key, value := range flattenCredentials(credentials, prefix, ".") {
+		name := envName(key)
+		if other, ok := keys[name]; ok {
+			if other > key {
+				other, key = key, other
+			}
+			return nil, nil, fmt.Errorf("credentials '%s' and '%s' would both be exported as %s", other, key, name)
+		}
+		keys[name] = key
+		values[name] = value
+	}
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+var plainEnvValue = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,=-]*$`)
+
+// envQuote leaves simple values as they are, and quotes others like dotenvQuote
+func envQuote(value string) string {
+	if plainEnvValue.MatchString(value) {
+		return value
+	}
+	return dotenvQuote(value)
+}
+
+func dotenvQuote(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "$", `\$`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// netrcEntry renders credentials as a .netrc entry, taking the host, login
+// and password from their uri (or url), or from host, username and password
+// keys; .netrc has no quoting, so values with whitespace are an error
+func netrcEntry(credentials map[string]interface{}) (string, error) {
+	str := func(keys ...string) string {
+		for _, key := range keys {
+			if value, ok := credentials[key].(string); ok && value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+	var machine, login, password string
+	if uri := str("uri", "url"); uri != "" {
+		if parsed, err := url.Parse(uri); err == nil && parsed.Hostname() != "" {
+			machine = parsed.Hostname()
+			if parsed.User != nil {
+				login = parsed.User.Username()
+				password, _ = parsed.User.Password()
+			}
+		}
+	}
+	if machine == "" {
+		machine = str("host", "hostname")
+		if hosts, ok := credentials["hosts"].([]interface{}); ok && machine == "" && len(hosts) > 0 {
+			machine = scalarString(hosts[0])
+		}
+	}
+	if login == "" {
+		login = str("username", "user")
+	}
+	if password == "" {
+		password = str("password")
+	}
+	if machine == "" || password == "" {
+		return "", fmt.Errorf("credentials have no host and password for a .netrc entry")
+	}
+	for _, value := range []string{machine, login, password} {
+		if strings.ContainsAny(value, " \t\r\n") {
+			return "", fmt.Errorf("credentials contain whitespace, which cannot be written to .netrc")
+		}
+	}
+	entry := "machine " + machine + "\n"
+	if login != "" {
+		entry += "  login " + login + "\n"
+	}
+	return entry + "  password " + password + "\n", nil
+}
+
+var nonK8sNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// k8sSecret renders credentials as a Kubernetes Secret manifest
+func k8sSecret(name string, credentials interface{}) (string, error) {
+	name = strings.Trim(nonK8sNameChars.ReplaceAllString(strings.ToLower(name), "-"), "-.")
+	data := yaml.MapSlice{}
+	flat := flattenCredentials(credentials, "", ".")
+	keys := make([]string, 0, len(flat))
+	for key := range flat {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		data = append(data, yaml.MapItem{Key: key, Value: base64.StdEncoding.EncodeToString([]byte(flat[key]))})
+	}
+	secret := yaml.MapSlice{
+		{Key: "apiVersion", Value: "v1"},
+		{Key: "kind", Value: "Secret"},
+		{Key: "metadata", Value: yaml.MapSlice{{Key: "name", Value: name}}},
+		{Key: "type", Value: "Opaque"},
+		{Key: "data", Value: data},
+	}
+	b, err := yaml.Marshal(secret)
+	if err != nil {
+		return "", errwrap.Wrapf("Could not marshal Kubernetes secret: {{err}}", err)
+	}
+	return "---\n" + string(b), nil
+}
+
+type vcapService struct {
+	Name         string                 `json:"name"`
+	InstanceName string                 `json:"instance_name"`
+	InstanceGUID string                 `json:"instance_guid"`
+	BindingName  string                 `json:"binding_name"`
+	BindingGUID  string                 `json:"binding_guid"`
+	Label        string                 `json:"label"`
+	Plan         string                 `json:"plan"`
+	Tags         []string               `json:"tags"`
+	Credentials  map[string]interface{} `json:"credentials"`
+}
+
+// vcapServices builds a Cloud Foundry style VCAP_SERVICES document from all
+// bindings of the service instances
+func vcapServices(instances []*edenstore.FSServiceInstance) (string, error) {
+	services := map[string][]vcapService{}
+	for _, inst := range instances {
+		for _, binding := range inst.Bindings {
+			credentials, err := binding.CredentialsJSON()
+			if err != nil {
+				return "", err
+			}
+			services[inst.ServiceName] = append(services[inst.ServiceName], vcapService{
+				Name:         inst.Name,
+				InstanceName: inst.Name,
+				InstanceGUID: inst.ID,
+				BindingName:  binding.Name,
+				BindingGUID:  binding.ID,
+				Label:        inst.ServiceName,
+				Plan:         inst.PlanName,
+				Tags:         []string{},
+				Credentials:  credentials,
+			})
+		}
+	}
+	b, err := json.Marshal(services)
+	if err != nil {
+		return "", errwrap.Wrapf("Could not marshal VCAP_SERVICES: {{err}}", err)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,567 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pathSegment is one step of an --attribute path
+type pathSegment struct {
+	key       string
+	index     *int
+	wildcard  bool
+	recursive bool
+	keys      []string
+	indices   []int
+	slice     *pathSlice
+	filter    *pathFilter
+}
+
+// pathSlice selects array elements from start up to, not including, end
+type pathSlice struct {
+	start, end *int
+	step       int
+}
+
+// pathFilter selects the children for which the path, relative to each
+// child (@), exists or compares to value
+type pathFilter struct {
+	path  []pathSegment
+	op    string
+	value interface{}
+}
+
+// parseAttributePath parses dotted paths (admin.password, hosts.0), and
+// JSONPath/jq style expressions: $.hosts[0], .admin.password, $..password,
+// hosts[*], .hosts[], hosts[0:2], hosts[-1:], hosts[0,2], ['key.with.dots'],
+// ['user','password'] and filters like endpoints[?(@.port > 5000)].host
+func parseAttributePath(path string) ([]pathSegment, error) {
+	segments := []pathSegment{}
+	rest := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	recursive := false
+
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			recursive = true
+			rest = rest[2:]
+			continue
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			continue
+		case strings.HasPrefix(rest, "["):
+			end := closingBracket(rest)
+			if end == -1 {
+				return nil, fmt.Errorf("missing ']' in '%s'", path)
+			}
+			segment, err := parseBracket(strings.TrimSpace(rest[1:end]))
+			if err != nil {
+				return nil, fmt.Errorf("%s in '%s'", err, path)
+			}
+			rest = rest[end+1:]
+			segment.recursive = recursive
+			segments = append(segments, segment)
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			rest = rest[end:]
+			segment := pathSegment{key: name, recursive: recursive}
+			if name == "*" {
+				segment.wildcard = true
+				segment.key = ""
+			}
+			segments = append(segments, segment)
+		}
+		recursive = false
+	}
+	if recursive {
+		return nil, fmt.Errorf("'..' must be followed by a key in '%s'", path)
+	}
+	return segments, nil
+}
+
+// closingBracket returns the index of the ']' closing the '[' that s starts
+// with, skipping nested brackets and quoted strings, or -1
+func closingBracket(s string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitOutsideQuotes splits s at each sep that is not within quotes
+func splitOutsideQuotes(s string, sep byte) []string {
+	parts := []string{}
+	var quote byte
+	last := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == sep:
+			parts = append(parts, s[last:i])
+			last = i + 1
+		}
+	}
+	return append(parts, s[last:])
+}
+
+// unquote returns the contents of a quoted string, if s is one
+func unquote(s string) (string, bool) {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1], true
+	}
+	return "", false
+}
+
+// parseBracket parses the inside of [...]: a wildcard, filter, slice, union,
+// quoted key or index
+func parseBracket(inner string) (pathSegment, error) {
+	segment := pathSegment{}
+	switch {
+	case inner == "" || inner == "*":
+		segment.wildcard = true
+	case strings.HasPrefix(inner, "?"):
+		filter, err := parseFilter(strings.TrimSpace(inner[1:]))
+		if err != nil {
+			return segment, err
+		}
+		segment.filter = filter
+	case len(splitOutsideQuotes(inner, ':')) > 1:
+		slice, err := parseSlice(inner)
+		if err != nil {
+			return segment, err
+		}
+		segment.slice = slice
+	case len(splitOutsideQuotes(inner, ',')) > 1:
+		for _, part := range splitOutsideQuotes(inner, ',') {
+			part = strings.TrimSpace(part)
+			if key, ok := unquote(part); ok {
+				segment.keys = append(segment.keys, key)
+			} else if i, err := strconv.Atoi(part); err == nil {
+				segment.indices = append(segment.indices, i)
+			} else {
+				return segment, fmt.Errorf("invalid key or index '%s' in '[%s]'", part, inner)
+			}
+		}
+	default:
+		if key, ok := unquote(inner); ok {
+			segment.key = key
+			break
+		}
+		i, err := strconv.Atoi(inner)
+		if err != nil {
+			return segment, fmt.Errorf("invalid index '[%s]'", inner)
+		}
+		segment.index = &i
+	}
+	return segment, nil
+}
+
+// parseSlice parses start:end or start:end:step, each of which may be omitted
+func parseSlice(inner string) (*pathSlice, error) {
+	parts := strings.Split(inner, ":")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("invalid slice '[%s]'", inner)
+	}
+	slice := &pathSlice{step: 1}
+	bounds := []**int{&slice.start, &slice.end}
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid slice '[%s]'", inner)
+		}
+		if i == 2 {
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid slice '[%s]', the step must be positive", inner)
+			}
+			slice.step = n
+			continue
+		}
+		*bounds[i] = &n
+	}
+	return slice, nil
+}
+
+var filterOperators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// parseFilter parses (@.path), (@.path OP value) or the same without
+// parentheses; values are quoted strings, numbers, true, false or null
+func parseFilter(expr string) (*pathFilter, error) {
+	if strings.HasPrefix(expr, "(") && strings.HasSuffix(expr, ")") {
+		expr = strings.TrimSpace(expr[1 : len(expr)-1])
+	}
+	left, right, op := expr, "", ""
+	var quote byte
+	for i := 0; i < len(expr) && op == ""; i++ {
+		switch c := expr[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		default:
+			for _, candidate := range filterOperators {
+				if strings.HasPrefix(expr[i:], candidate) {
+					left, op, right = expr[:i], candidate, expr[i+len(candidate):]
+					break
+				}
+			}
+		}
+	}
+	left = strings.TrimSpace(left)
+	if !strings.HasPrefix(left, "@") {
+		return nil, fmt.Errorf("filter '%s' must start with @", expr)
+	}
+	path, err := parseAttributePath(left[1:])
+	if err != nil {
+		return nil, err
+	}
+	filter := &pathFilter{path: path, op: op}
+	if op == "" {
+		return filter, nil
+	}
+	right = strings.TrimSpace(right)
+	if value, ok := unquote(right); ok {
+		filter.value = value
+		return filter, nil
+	}
+	switch right {
+	case "true":
+		filter.value = true
+	case "false":
+		filter.value = false
+	case "null":
+		filter.value = nil
+	default:
+		n, err := strconv.ParseFloat(right, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value '%s' in filter '%s'", right, expr)
+		}
+		filter.value = n
+	}
+	return filter, nil
+}
+
+// lookupAttribute evaluates an --attribute path against credentials. A
+// top-level key matching the whole path exactly takes precedence, so that
+// keys containing dots still work.
+func lookupAttribute(credentials map[string]interface{}, path string) ([]interface{}, error) {
+	if val, ok := credentials[path]; ok {
+		return []interface{}{val}, nil
+	}
+	segments, err := parseAttributePath(path)
+	if err != nil {
+		return nil, err
+	}
+	return evaluatePath(segments, []interface{}{credentials}), nil
+}
+
+// evaluatePath applies each segment in turn to all nodes matched so far
+func evaluatePath(segments []pathSegment, nodes []interface{}) []interface{} {
+	for _, segment := range segments {
+		if segment.recursive {
+			nodes = descendants(nodes)
+		}
+		next := []interface{}{}
+		for _, node := range nodes {
+			next = append(next, segment.apply(node)...)
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+func (segment pathSegment) apply(node interface{}) []interface{} {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		children := make([]interface{}, 0, len(v))
+		switch {
+		case segment.wildcard, segment.filter != nil:
+			for _, key := range sortedKeys(v) {
+				children = append(children, v[key])
+			}
+			if segment.filter != nil {
+				return segment.filter.apply(children)
+			}
+			return children
+		case len(segment.keys) > 0:
+			for _, key := range segment.keys {
+				if child, ok := v[key]; ok {
+					children = append(children, child)
+				}
+			}
+			return children
+		}
+		if child, ok := v[segment.key]; ok && segment.index == nil && segment.slice == nil && len(segment.indices) == 0 {
+			return []interface{}{child}
+		}
+	case []interface{}:
+		switch {
+		case segment.wildcard:
+			return v
+		case segment.filter != nil:
+			return segment.filter.apply(v)
+		case segment.slice != nil:
+			return segment.slice.apply(v)
+		case len(segment.indices) > 0:
+			children := []interface{}{}
+			for _, i := range segment.indices {
+				if child, ok := arrayElement(v, i); ok {
+					children = append(children, child)
+				}
+			}
+			return children
+		}
+		i := 0
+		if segment.index != nil {
+			i = *segment.index
+		} else if n, err := strconv.Atoi(segment.key); err == nil {
+			i = n
+		} else {
+			return nil
+		}
+		if child, ok := arrayElement(v, i); ok {
+			return []interface{}{child}
+		}
+	}
+	return nil
+}
+
+// arrayElement returns element i of array, counting from the end if negative
+func arrayElement(array []interface{}, i int) (interface{}, bool) {
+	if i < 0 {
+		i += len(array)
+	}
+	if i < 0 || i >= len(array) {
+		return nil, false
+	}
+	return array[i], true
+}
+
+func (slice pathSlice) apply(array []interface{}) []interface{} {
+	bound := func(b *int, fallback int) int {
+		if b == nil {
+			return fallback
+		}
+		i := *b
+		if i < 0 {
+			i += len(array)
+		}
+		if i < 0 {
+			return 0
+		}
+		if i > len(array) {
+			return len(array)
+		}
+		return i
+	}
+	children := []interface{}{}
+	for i := bound(slice.start, 0); i < bound(slice.end, len(array)); i += slice.step {
+		children = append(children, array[i])
+	}
+	return children
+}
+
+func (filter pathFilter) apply(children []interface{}) []interface{} {
+	matched := []interface{}{}
+	for _, child := range children {
+		for _, value := range evaluatePath(filter.path, []interface{}{child}) {
+			if filter.op == "" || compareFilterValue(value, filter.op, filter.value) {
+				matched = append(matched, child)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// compareFilterValue compares numbers numerically and strings
+// lexicographically; other values can only be compared with == and !=
+func compareFilterValue(value interface{}, op string, expected interface{}) bool {
+	cmp := 0
+	switch v := value.(type) {
+	case float64:
+		e, ok := expected.(float64)
+		if !ok {
+			return op == "!="
+		}
+		if v < e {
+			cmp = -1
+		} else if v > e {
+			cmp = 1
+		}
+	case string:
+		e, ok := expected.(string)
+		if !ok {
+			return op == "!="
+		}
+		cmp = strings.Compare(v, e)
+	default:
+		equal := value == expected
+		switch op {
+		case "==":
+			return equal
+		case "!=":
+			return !equal
+		}
+		return false
+	}
+	switch op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+// descendants returns nodes and all of their nested children, depth first
+func descendants(nodes []interface{}) []interface{} {
+	result := []interface{}{}
+	var walk func(node interface{})
+	walk = func(node interface{}) {
+		result = append(result, node)
+		switch v := node.(type) {
+		case map[string]interface{}:
+			for _, key := range sortedKeys(v) {
+				walk(v[key])
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	for _, node := range nodes {
+		walk(node)
+	}
+	return result
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// attributePaths lists the dotted paths of all nested keys, for suggestions
+func attributePaths(credentials map[string]interface{}) []string {
+	paths := []string{}
+	var walk func(node interface{}, prefix string)
+	walk = func(node interface{}, prefix string) {
+		join := func(child string) string {
+			if prefix == "" {
+				return child
+			}
+			return prefix + "." + child
+		}
+		switch v := node.(type) {
+		case map[string]interface{}:
+			for _, key := range sortedKeys(v) {
+				paths = append(paths, join(key))
+				walk(v[key], join(key))
+			}
+		case []interface{}:
+			for i, child := range v {
+				paths = append(paths, join(strconv.Itoa(i)))
+				walk(child, join(strconv.Itoa(i)))
+			}
+		}
+	}
+	walk(credentials, "")
+	return paths
+}
+
+// suggestAttributes returns up to 3 known paths closest to the unresolved path
+func suggestAttributes(credentials map[string]interface{}, path string) []string {
+	normalized := strings.NewReplacer("$", "", "[", ".", "]", "", "'", "", `"`, "").Replace(path)
+	normalized = strings.Trim(strings.Replace(normalized, "..", ".", -1), ".")
+
+	type candidate struct {
+		path     string
+		distance int
+	}
+	candidates := []candidate{}
+	for _, known := range attributePaths(credentials) {
+		distance := levenshtein(strings.ToLower(normalized), strings.ToLower(known))
+		if distance <= len(normalized)/3+1 || strings.HasSuffix(known, "."+normalized) {
+			candidates = append(candidates, candidate{known, distance})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+	suggestions := []string{}
+	for i := 0; i < len(candidates) && i < 3; i++ {
+		suggestions = append(suggestions, candidates[i].path)
+	}
+	return suggestions
+}
+
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
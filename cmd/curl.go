@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+)
+
+// CurlOpts represents the 'curl' command
+type CurlOpts struct {
+	Method  string   `short:"X" long:"request" description:"HTTP method" default:"GET"`
+	Data    string   `short:"d" long:"data" description:"Request body; @FILE reads it from a file, @- from stdin"`
+	Headers []string `short:"H" long:"header" description:"Extra request header, 'Name: value' (can be repeated)"`
+	Fail    bool     `short:"f" long:"fail" description:"Exit with an error if the response status is 400 or above"`
+}
+
+// curlResult is the response to a raw broker request
+type curlResult struct {
+	Status int         `json:"status"`
+	Body   interface{} `json:"body"`
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c CurlOpts) Execute(args []string) (err error) {
+	if len(args) != 1 {
+		return fmt.Errorf("curl requires a broker path, e.g. eden curl /v2/catalog")
+	}
+	path := args[0]
+	if strings.Contains(path, "://") {
+		return fmt.Errorf("curl path '%s' must be relative to the broker URL, e.g. /v2/catalog", path)
+	}
+
+	headers := http.Header{}
+	for _, header := range c.Headers {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return fmt.Errorf("curl --header '%s' must be 'Name: value'", header)
+		}
+		headers.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	var body io.Reader
+	if c.Data != "" {
+		data := []byte(c.Data)
+		switch {
+		case c.Data == "@-":
+			data, err = ioutil.ReadAll(os.Stdin)
+		case strings.HasPrefix(c.Data, "@"):
+			data, err = ioutil.ReadFile(strings.TrimPrefix(c.Data, "@"))
+		}
+		if err != nil {
+			return errwrap.Wrapf("Could not read request body: {{err}}", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	broker, err := Opts.broker()
+	if err != nil {
+		return err
+	}
+	resp, resBody, err := broker.Request(strings.ToUpper(c.Method), path, body, headers)
+	if err != nil {
+		return err
+	}
+
+	result := curlResult{Status: resp.StatusCode, Body: strings.TrimSpace(string(resBody))}
+	var data interface{}
+	if json.Unmarshal(resBody, &data) == nil {
+		result.Body = data
+	}
+	err = render(result, func() error {
+		fmt.Fprintf(os.Stderr, "%s %s\n", resp.Proto, resp.Status)
+		if data == nil {
+			fmt.Println(result.Body)
+			return nil
+		}
+		pretty, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return errwrap.Wrapf("Could not marshal response: {{err}}", err)
+		}
+		fmt.Println(string(pretty))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if c.Fail && resp.StatusCode >= 400 {
+		return fmt.Errorf("curl: broker responded %s", resp.Status)
+	}
+	return nil
+}
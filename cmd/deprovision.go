@@ -3,7 +3,6 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"time"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/pivotal-cf/brokerapi"
@@ -12,6 +11,8 @@ import (
 
 // DeprovisionOpts represents the 'deprovision' command
 type DeprovisionOpts struct {
+	Cascade bool `long:"cascade" description:"Unbind all bindings of the instance before deprovisioning it"`
+	Yes     bool `short:"y" long:"yes" description:"Do not ask for confirmation"`
 }
 
 // Execute is callback from go-flags.Commander interface
@@ -21,35 +22,96 @@ func (c DeprovisionOpts) Execute(_ []string) (err error) {
 		return fmt.Errorf("deprovision command requires --instance [NAME|GUID], or $SB_INSTANCE")
 	}
 	instance := Opts.config().FindServiceInstance(instanceNameOrID)
+	if instance.ServiceID == "" {
+		return fmt.Errorf("deprovision --instance '%s' was not found", instanceNameOrID)
+	}
+	if instance.Protected {
+		return fmt.Errorf("deprovision: '%s' is protected; run 'eden -i %s protect --off' first", instance.Name, instance.Name)
+	}
+	if len(instance.Bindings) > 0 && !c.Cascade {
+		return fmt.Errorf("deprovision: '%s' has %d binding(s); unbind them first, or use --cascade", instance.Name, len(instance.Bindings))
+	}
+	if !c.Yes && Opts.DryRun == "" && isTerminal(os.Stdin) {
+		question := fmt.Sprintf("Deprovision '%s' (%s/%s)?", instance.Name, instance.ServiceName, instance.PlanName)
+		if len(instance.Bindings) > 0 {
+			question = fmt.Sprintf("Unbind %d binding(s) and deprovision '%s' (%s/%s)?",
+				len(instance.Bindings), instance.Name, instance.ServiceName, instance.PlanName)
+		}
+		if !confirm(question) {
+			return fmt.Errorf("deprovision: cancelled")
+		}
+	}
+
+	broker, err := Opts.broker()
+	if err != nil {
+		return err
+	}
+	unbound := []string{}
+	for _, binding := range instance.Bindings {
+		progress("deprovision: unbinding %s\n", binding.Name)
+		if err = broker.Unbind(instance.ServiceID, instance.PlanID, instance.ID, binding.ID); err != nil && !apiclient.IsDryRun(err) {
+			return errwrap.Wrapf(fmt.Sprintf("Failed to unbind %s {{err}}", binding.Name), err)
+		}
+		if err = Opts.config().UnbindServiceInstance(instance.ID, binding.ID); err != nil {
+			return err
+		}
+		unbound = append(unbound, binding.ID)
+	}
 
-	broker := apiclient.NewOpenServiceBroker(
-		Opts.Broker.URLOpt,
-		Opts.Broker.ClientOpt,
-		Opts.Broker.ClientSecretOpt,
-		Opts.Broker.APIVersion,
-	)
 	resp, isAsync, err := broker.Deprovision(instance.ServiceID, instance.PlanID, instance.ID)
+	if apiclient.IsDryRun(err) {
+		return nil
+	}
 	if err != nil {
 		return errwrap.Wrapf("Failed to deprovision service instance {{err}}", err)
 	}
 
-	fmt.Printf("deprovision: %s/%s - guid: %s\n", instance.ServiceName, instance.PlanName, instance.ID)
+	result := deprovisionResult{
+		ID:          instance.ID,
+		Name:        instance.Name,
+		ServiceName: instance.ServiceName,
+		PlanName:    instance.PlanName,
+		Unbound:     unbound,
+		Async:       isAsync,
+		State:       string(brokerapi.Succeeded),
+	}
+	progress("deprovision: %s/%s - guid: %s\n", instance.ServiceName, instance.PlanName, instance.ID)
 	if isAsync {
-		fmt.Println("deprovision: in-progress")
-		// TODO: don't pollute brokerapi back into this level
-		lastOpResp := &brokerapi.LastOperationResponse{State: brokerapi.InProgress}
-		for lastOpResp.State == brokerapi.InProgress {
-			time.Sleep(5 * time.Second)
-			lastOpResp, err = broker.LastOperation(instance.ServiceID, instance.PlanID, instance.ID, resp.OperationData)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err.Error())
-				os.Exit(1)
+		progress("deprovision: in-progress\n")
+		lastOpResp, err := waitForLastOperation(broker, "deprovision", instance.ServiceID, instance.PlanID, instance.ID, resp.OperationData,
+			func(lastOpResp *brokerapi.LastOperationResponse) {
+				progress("deprovision: %s - %s\n", lastOpResp.State, lastOpResp.Description)
+			})
+		if err != nil {
+			return err
+		}
+		result.State = string(lastOpResp.State)
+		result.Description = lastOpResp.Description
+		if err = lastOperationError(lastOpResp); err != nil {
+			if recordErr := Opts.config().RecordLastOperation(instance.ID, "deprovision", result.State, result.Description); recordErr != nil {
+				return errwrap.Wrapf("Failed to store last operation {{err}}", recordErr)
 			}
-			fmt.Printf("deprovision: %s - %s\n", lastOpResp.State, lastOpResp.Description)
+			return errwrap.Wrapf("Failed to deprovision service instance {{err}}", err)
 		}
 	}
-	Opts.config().DeprovisionServiceInstance(instance.ID)
-	fmt.Println("deprovision: done")
+	if err = Opts.config().DeprovisionServiceInstance(instance.ID); err != nil {
+		return errwrap.Wrapf("Failed to remove service instance from store {{err}}", err)
+	}
+
+	return render(result, func() error {
+		fmt.Println("deprovision: done")
+		return nil
+	})
+}
 
-	return
+// deprovisionResult is the structured output of the 'deprovision' command
+type deprovisionResult struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	ServiceName string   `json:"service_name"`
+	PlanName    string   `json:"plan_name"`
+	Unbound     []string `json:"unbound_binding_ids,omitempty"`
+	Async       bool     `json:"async"`
+	State       string   `json:"state"`
+	Description string   `json:"description,omitempty"`
 }
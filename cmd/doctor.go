@@ -0,0 +1,284 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jhunt/go-table"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/starkandwayne/eden/apiclient"
+	edenstore "github.com/starkandwayne/eden/store"
+)
+
+// DoctorOpts represents the 'doctor' command
+type DoctorOpts struct {
+	Fix        bool          `long:"fix" description:"Remove records of missing instances and bindings and of failed provisions, and correct mismatched plans"`
+	StuckAfter time.Duration `long:"stuck-after" description:"Report operations still in progress after this long as stuck" default:"1h"`
+}
+
+// doctorCheck is the diagnosis of one instance or binding record
+type doctorCheck struct {
+	BrokerURL  string `json:"broker_url"`
+	InstanceID string `json:"instance_id"`
+	Instance   string `json:"instance"`
+	BindingID  string `json:"binding_id,omitempty"`
+	Binding    string `json:"binding,omitempty"`
+	Status     string `json:"status"`
+	Detail     string `json:"detail,omitempty"`
+	Fixed      bool   `json:"fixed"`
+
+	fix func() error
+}
+
+// Statuses of doctor checks
+const (
+	doctorHealthy      = "healthy"
+	doctorMissing      = "missing"
+	doctorOrphaned     = "orphaned"
+	doctorStuck        = "stuck-in-progress"
+	doctorFailed       = "failed-provision"
+	doctorPlanMismatch = "plan-mismatched"
+	doctorCheckFailed  = "error"
+	doctorUnsupported  = "unsupported"
+)
+
+// Execute is callback from go-flags.Commander interface
+func (c DoctorOpts) Execute(_ []string) (err error) {
+	// the credentials are only for the target broker, so only its instances are checked
+	brokerURL := Opts.Broker.URLOpt
+	instances := []*edenstore.FSServiceInstance{}
+	others := 0
+	for _, inst := range Opts.config().ServiceInstances() {
+		if Opts.isTargetBroker(inst.BrokerURL) {
+			instances = append(instances, inst)
+		} else {
+			others++
+		}
+	}
+	if others > 0 {
+		progress("doctor: skipping %d instance(s) of other brokers; target them with --url to check them\n", others)
+	}
+
+	checks := []*doctorCheck{}
+	if len(instances) > 0 {
+		broker, err := Opts.broker()
+		if err != nil {
+			return err
+		}
+		progress("doctor: checking %d instance(s) of %s\n", len(instances), brokerURL)
+		for _, inst := range instances {
+			checks = append(checks, c.checkInstance(broker, brokerURL, inst)...)
+		}
+	}
+
+	problems := 0
+	for _, check := range checks {
+		if check.Status == doctorHealthy || check.Status == doctorUnsupported {
+			continue
+		}
+		if c.Fix && check.fix != nil {
+			if err := check.fix(); err != nil {
+				check.Detail = fmt.Sprintf("%s; fix failed: %s", check.Detail, err)
+			} else {
+				check.Fixed = true
+				continue
+			}
+		}
+		problems++
+	}
+
+	err = render(checks, func() error {
+		if len(checks) == 0 {
+			fmt.Println("doctor: no service instances")
+			return nil
+		}
+		table := table.NewTable("Broker URL", "Instance", "Binding", "Status", "Detail")
+		for _, check := range checks {
+			status := check.Status
+			if check.Fixed {
+				status += " (fixed)"
+			}
+			table.Row(nil, check.BrokerURL, check.Instance, check.Binding, status, check.Detail)
+		}
+		table.Output(os.Stdout)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if problems > 0 {
+		if c.Fix {
+			return fmt.Errorf("doctor: %d problem(s) could not be fixed", problems)
+		}
+		return fmt.Errorf("doctor: %d problem(s) found; run 'eden doctor --fix' to repair the records that can be", problems)
+	}
+	return nil
+}
+
+// checkInstance compares an instance record, and those of its bindings, with the broker
+func (c DoctorOpts) checkInstance(broker *apiclient.OpenServiceBroker, brokerURL string, inst *edenstore.FSServiceInstance) []*doctorCheck {
+	check := &doctorCheck{BrokerURL: brokerURL, InstanceID: inst.ID, Instance: inst.Name, Status: doctorHealthy}
+	if inst.ID == "" || inst.ServiceID == "" || inst.PlanID == "" {
+		check.Status = doctorOrphaned
+		check.Detail = "incomplete record, without an instance, service or plan ID"
+		check.fix = func() error {
+			if inst.ID == "" {
+				return Opts.config().DeprovisionServiceInstance(inst.Name)
+			}
+			return Opts.config().DeprovisionServiceInstance(inst.ID)
+		}
+		return []*doctorCheck{check}
+	}
+	if op := inst.LastOperation; op != nil && op.Type == "provision" && op.State == string(brokerapi.Failed) {
+		check.Status = doctorFailed
+		check.Detail = "provision failed"
+		if op.Description != "" {
+			check.Detail += ": " + op.Description
+		}
+		check.fix = func() error {
+			return Opts.config().DeprovisionServiceInstance(inst.ID)
+		}
+		return []*doctorCheck{check}
+	}
+
+	// brokers only implement GET for the instances of services that opt in
+	instancesRetrievable, err := broker.InstancesRetrievable(inst.ServiceID)
+	if err != nil {
+		check.Status = doctorCheckFailed
+		check.Detail = err.Error()
+		return []*doctorCheck{check}
+	}
+	if !instancesRetrievable {
+		c.checkLastOperation(broker, check, inst)
+		if check.Status == doctorMissing {
+			return []*doctorCheck{check}
+		}
+		return append([]*doctorCheck{check}, checkBindings(broker, brokerURL, inst)...)
+	}
+
+	remote, found, err := broker.GetInstance(inst.ServiceID, inst.ID)
+	if err != nil || !found {
+		// instances being provisioned are not found, and those being updated
+		// may return an error (422 ConcurrencyError)
+		lastOpResp, lastOpErr := broker.LastOperation(inst.ServiceID, inst.PlanID, inst.ID, "")
+		if lastOpErr == nil && lastOpResp.State == brokerapi.InProgress {
+			return []*doctorCheck{c.checkInProgress(check, inst, lastOpResp)}
+		}
+	}
+	if err != nil {
+		check.Status = doctorCheckFailed
+		check.Detail = err.Error()
+		return []*doctorCheck{check}
+	}
+	if !found {
+		check.Status = doctorMissing
+		check.Detail = "broker does not have this instance"
+		check.fix = func() error {
+			return Opts.config().DeprovisionServiceInstance(inst.ID)
+		}
+		return []*doctorCheck{check}
+	}
+
+	if remote.PlanID != "" && remote.PlanID != inst.PlanID {
+		check.Status = doctorPlanMismatch
+		check.Detail = fmt.Sprintf("broker has plan %s, record has %s", remote.PlanID, inst.PlanID)
+		check.fix = func() error {
+			planName := remote.PlanID
+			if service, err := broker.FindServiceByNameOrID(inst.ServiceID); err == nil {
+				if plan, err := broker.FindPlanByNameOrID(service, remote.PlanID); err == nil {
+					planName = plan.Name
+				}
+			}
+			return Opts.config().UpdateServiceInstance(inst.ID, remote.PlanID, planName, json.RawMessage(inst.Parameters))
+		}
+	}
+
+	return append([]*doctorCheck{check}, checkBindings(broker, brokerURL, inst)...)
+}
+
+// checkLastOperation checks an instance that the broker does not allow
+// fetching through its last_operation instead: 410 Gone means that the broker
+// no longer has it. Brokers need not implement last_operation for instances
+// without async operations, so other errors are reported as unsupported.
+func (c DoctorOpts) checkLastOperation(broker *apiclient.OpenServiceBroker, check *doctorCheck, inst *edenstore.FSServiceInstance) {
+	lastOpResp, err := broker.LastOperation(inst.ServiceID, inst.PlanID, inst.ID, "")
+	switch {
+	case err == apiclient.ErrGone:
+		check.Status = doctorMissing
+		check.Detail = "broker does not have this instance"
+		check.fix = func() error {
+			return Opts.config().DeprovisionServiceInstance(inst.ID)
+		}
+	case err != nil:
+		check.Status = doctorUnsupported
+		check.Detail = fmt.Sprintf("catalog does not set instances_retrievable for the service, and last_operation failed: %s", err)
+	case lastOpResp.State == brokerapi.InProgress:
+		c.checkInProgress(check, inst, lastOpResp)
+	}
+}
+
+// checkInProgress reports an operation that has been in progress for longer than --stuck-after
+func (c DoctorOpts) checkInProgress(check *doctorCheck, inst *edenstore.FSServiceInstance, lastOpResp *brokerapi.LastOperationResponse) *doctorCheck {
+	since := inst.CreatedAt
+	if inst.LastOperation != nil {
+		since = inst.LastOperation.UpdatedAt
+	}
+	if time.Since(since) < c.StuckAfter {
+		check.Detail = fmt.Sprintf("operation in progress: %s", lastOpResp.Description)
+		return check
+	}
+	check.Status = doctorStuck
+	check.Detail = fmt.Sprintf("operation in progress for %s: %s", time.Since(since).Round(time.Minute), lastOpResp.Description)
+	return check
+}
+
+// checkBindings checks the binding records of an instance, if the catalog
+// allows fetching them
+func checkBindings(broker *apiclient.OpenServiceBroker, brokerURL string, inst *edenstore.FSServiceInstance) []*doctorCheck {
+	checks := []*doctorCheck{}
+	if len(inst.Bindings) == 0 {
+		return checks
+	}
+	bindingsRetrievable, err := broker.BindingsRetrievable(inst.ServiceID)
+	for _, binding := range inst.Bindings {
+		check := &doctorCheck{
+			BrokerURL:  brokerURL,
+			InstanceID: inst.ID,
+			Instance:   inst.Name,
+			BindingID:  binding.ID,
+			Binding:    binding.Name,
+			Status:     doctorHealthy,
+		}
+		switch {
+		case err != nil:
+			check.Status = doctorCheckFailed
+			check.Detail = err.Error()
+		case !bindingsRetrievable:
+			check.Status = doctorUnsupported
+			check.Detail = "catalog does not set bindings_retrievable for the service"
+		default:
+			checkBinding(broker, check, inst, binding)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// checkBinding reports a binding record that the broker no longer has as orphaned
+func checkBinding(broker *apiclient.OpenServiceBroker, check *doctorCheck, inst *edenstore.FSServiceInstance, binding edenstore.FSServiceBinding) {
+	_, found, err := broker.GetBinding(inst.ServiceID, inst.ID, binding.ID)
+	if err != nil {
+		check.Status = doctorCheckFailed
+		check.Detail = err.Error()
+		return
+	}
+	if !found {
+		check.Status = doctorOrphaned
+		check.Detail = "broker does not have this binding"
+		check.fix = func() error {
+			return Opts.config().UnbindServiceInstance(inst.ID, binding.ID)
+		}
+	}
+}
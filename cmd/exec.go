@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/template"
+
+	"github.com/hashicorp/errwrap"
+	edenstore "github.com/starkandwayne/eden/store"
+)
+
+// ExecOpts represents the 'exec' command
+type ExecOpts struct {
+	BindingID string   `short:"b" long:"bind" description:"Binding to use (default: most recent)"`
+	Prefix    string   `long:"prefix" description:"Prefix for credentials environment variable names"`
+	Env       []string `short:"e" long:"env" description:"Additional variable rendered from credentials, e.g. --env 'DATABASE_URL={{.uri}}' (can be repeated)"`
+	NoVCAP    bool     `long:"no-vcap" description:"Do not set VCAP_SERVICES"`
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c ExecOpts) Execute(args []string) (err error) {
+	if len(args) == 0 {
+		return fmt.Errorf("USAGE: eden exec -i [instance] [-b binding] -- command [args...]")
+	}
+	instanceNameOrID := Opts.Instance.NameOrID
+	if instanceNameOrID == "" {
+		return fmt.Errorf("exec command requires --instance [NAME|GUID], or $SB_INSTANCE")
+	}
+	inst := Opts.config().FindServiceInstance(instanceNameOrID)
+	if inst.ServiceID == "" {
+		return fmt.Errorf("exec --instance '%s' was not found", instanceNameOrID)
+	}
+	env, err := c.environment(inst)
+	if err != nil {
+		return err
+	}
+
+	os.Exit(runWithEnv(args, env, os.Stdout))
+	return
+}
+
+// environment returns the current environment plus the binding credentials
+func (c ExecOpts) environment(inst edenstore.FSServiceInstance) ([]string, error) {
+	binding, err := selectBinding(inst, c.BindingID)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := binding.CredentialsJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	env := os.Environ()
+	names, values, err := envVars(credentials, c.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		env = append(env, name+"="+values[name])
+	}
+	if !c.NoVCAP {
+		inst.Bindings = []edenstore.FSServiceBinding{*binding}
+		vcap, err := vcapServices([]*edenstore.FSServiceInstance{&inst})
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, "VCAP_SERVICES="+vcap)
+	}
+	for _, pair := range c.Env {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("exec --env '%s' must be in the form NAME=TEMPLATE", pair)
+		}
+		tmpl, err := template.New(parts[0]).Funcs(templateFuncs).Option("missingkey=error").Parse(parts[1])
+		if err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("Could not parse --env %s: {{err}}", parts[0]), err)
+		}
+		value := &bytes.Buffer{}
+		if err = tmpl.Execute(value, credentials); err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("Could not render --env %s: {{err}}", parts[0]), err)
+		}
+		env = append(env, parts[0]+"="+value.String())
+	}
+
+	return env, nil
+}
+
+// runWithEnv runs the command in the foreground, writing its output to stdout
+// and stderr and forwarding signals to it, and returns its exit code (128+signal
+// if it was killed by a signal)
+func runWithEnv(args []string, env []string, stdout io.Writer) int {
+	child := exec.Command(args[0], args[1:]...)
+	child.Env = env
+	child.Stdin = os.Stdin
+	child.Stdout = stdout
+	child.Stderr = os.Stderr
+
+	if err := child.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not run '%s': %s\n", args[0], err)
+		return 127
+	}
+
+	// eden keeps running until the child exits, whatever signals it gets
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			child.Process.Signal(sig)
+		}
+	}()
+
+	err := child.Wait()
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return 128 + int(status.Signal())
+			}
+			return status.ExitStatus()
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Failed running '%s': %s\n", args[0], err)
+	return 1
+}
@@ -0,0 +1,10 @@
+package cmd
+
+import (
+	"os"
+)
+
+// forwardedSignals are passed on to commands run by 'exec' and rotate hooks;
+// Windows cannot send signals to other processes, but Ctrl-C reaches every
+// process of the console, so it is only caught to keep eden running
+var forwardedSignals = []os.Signal{os.Interrupt}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/jhunt/go-table"
+)
+
+// Statuses of a testCase
+const (
+	testPassed  = "pass"
+	testFailed  = "fail"
+	testSkipped = "skip"
+)
+
+// testCase is the outcome of one check of the conformance and smoke suites
+type testCase struct {
+	Suite    string        `json:"suite"`
+	Name     string        `json:"name"`
+	Status   string        `json:"status"`
+	Duration time.Duration `json:"duration_ns"`
+	Message  string        `json:"message,omitempty"`
+}
+
+// printTestCases shows test cases as a table, followed by the totals
+func printTestCases(cases []*testCase) {
+	table := table.NewTable("Suite", "Test", "Result", "Duration", "Message")
+	passed, failed, skipped := 0, 0, 0
+	for _, tc := range cases {
+		switch tc.Status {
+		case testPassed:
+			passed++
+		case testFailed:
+			failed++
+		case testSkipped:
+			skipped++
+		}
+		table.Row(nil, tc.Suite, tc.Name, tc.Status, tc.Duration.Round(time.Millisecond).String(), tc.Message)
+	}
+	table.Output(os.Stdout)
+	fmt.Printf("\n%d passed, %d failed, %d skipped\n", passed, failed, skipped)
+}
+
+// failedTestCases counts the failed test cases
+func failedTestCases(cases []*testCase) int {
+	failed := 0
+	for _, tc := range cases {
+		if tc.Status == testFailed {
+			failed++
+		}
+	}
+	return failed
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// writeJUnit writes test cases to path as JUnit XML, with a testsuite per suite
+func writeJUnit(path string, cases []*testCase) error {
+	report := junitTestSuites{}
+	index := map[string]int{}
+	durations := map[string]time.Duration{}
+	for _, tc := range cases {
+		i, ok := index[tc.Suite]
+		if !ok {
+			i = len(report.Suites)
+			index[tc.Suite] = i
+			report.Suites = append(report.Suites, junitTestSuite{Name: tc.Suite})
+		}
+		suite := &report.Suites[i]
+		junitCase := junitTestCase{
+			Name:      tc.Name,
+			ClassName: tc.Suite,
+			Time:      fmt.Sprintf("%.3f", tc.Duration.Seconds()),
+		}
+		switch tc.Status {
+		case testFailed:
+			suite.Failures++
+			junitCase.Failure = &junitMessage{Message: tc.Message}
+		case testSkipped:
+			suite.Skipped++
+			junitCase.Skipped = &junitMessage{Message: tc.Message}
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, junitCase)
+		durations[tc.Suite] += tc.Duration
+	}
+	for i := range report.Suites {
+		report.Suites[i].Time = fmt.Sprintf("%.3f", durations[report.Suites[i].Name].Seconds())
+	}
+
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errwrap.Wrapf("Could not marshal JUnit report: {{err}}", err)
+	}
+	data = append([]byte(xml.Header), data...)
+	if err = ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return errwrap.Wrapf("Could not write JUnit report: {{err}}", err)
+	}
+	return nil
+}
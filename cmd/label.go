@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jhunt/go-table"
+	edenstore "github.com/starkandwayne/eden/store"
+)
+
+// LabelOpts represents the 'label' command
+type LabelOpts struct {
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c LabelOpts) Execute(args []string) (err error) {
+	instanceNameOrID := Opts.Instance.NameOrID
+	if instanceNameOrID == "" {
+		return fmt.Errorf("label command requires --instance [NAME|GUID], or $SB_INSTANCE")
+	}
+	inst := Opts.config().FindServiceInstance(instanceNameOrID)
+	if inst.ServiceID == "" {
+		return fmt.Errorf("label --instance '%s' was not found", instanceNameOrID)
+	}
+
+	set := map[string]string{}
+	remove := []string{}
+	for _, arg := range args {
+		if strings.HasSuffix(arg, "-") && !strings.Contains(arg, "=") {
+			key := strings.TrimSuffix(arg, "-")
+			if err = validateLabelKey(key); err != nil {
+				return err
+			}
+			remove = append(remove, key)
+			continue
+		}
+		key, value, err := parseLabel(arg)
+		if err != nil {
+			return err
+		}
+		set[key] = value
+	}
+	if len(set) > 0 || len(remove) > 0 {
+		if err = Opts.config().SetLabels(inst.ID, set, remove); err != nil {
+			return err
+		}
+		inst = Opts.config().FindServiceInstance(inst.ID)
+	}
+
+	labels := inst.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return render(labels, func() error {
+		if len(labels) == 0 {
+			fmt.Println("No labels.")
+			return nil
+		}
+		table := table.NewTable("Label", "Value")
+		for _, key := range sortedLabelKeys(labels) {
+			table.Row(nil, key, labels[key])
+		}
+		table.Output(os.Stdout)
+		return nil
+	})
+}
+
+// parseLabel splits KEY=VALUE into a validated label key and its value
+func parseLabel(label string) (key, value string, err error) {
+	parts := strings.SplitN(label, "=", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("label '%s' must be in the form KEY=VALUE, or KEY- to remove it", label)
+	}
+	if err = validateLabelKey(parts[0]); err != nil {
+		return "", "", err
+	}
+	if strings.Contains(parts[1], ",") {
+		return "", "", fmt.Errorf("label '%s' value cannot contain ','", label)
+	}
+	return parts[0], parts[1], nil
+}
+
+func validateLabelKey(key string) error {
+	if key == "" || strings.ContainsAny(key, "=!, \t") {
+		return fmt.Errorf("label key '%s' must be non-empty and cannot contain '=', '!', ',' or spaces", key)
+	}
+	return nil
+}
+
+func sortedLabelKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatLabels shows labels as a selector-like list, e.g. env=ci,owner=bob
+func formatLabels(labels map[string]string) string {
+	pairs := []string{}
+	for _, key := range sortedLabelKeys(labels) {
+		pairs = append(pairs, key+"="+labels[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// labelRequirement is a single term of a label selector
+type labelRequirement struct {
+	key      string
+	operator string // "=", "!=", "exists" or "!exists"
+	value    string
+}
+
+// labelSelector is a list of requirements that must all be met
+type labelSelector []labelRequirement
+
+// parseLabelSelector parses selectors such as "env=staging,owner!=bob,ci,!temp";
+// requirements from all of the given selectors must be met
+func parseLabelSelector(selectors []string) (labelSelector, error) {
+	selector := labelSelector{}
+	for _, expr := range selectors {
+		for _, term := range strings.Split(expr, ",") {
+			term = strings.TrimSpace(term)
+			if term == "" {
+				continue
+			}
+			req := labelRequirement{}
+			switch {
+			case strings.Contains(term, "!="):
+				parts := strings.SplitN(term, "!=", 2)
+				req = labelRequirement{key: parts[0], operator: "!=", value: parts[1]}
+			case strings.Contains(term, "="):
+				parts := strings.SplitN(term, "=", 2)
+				req = labelRequirement{key: parts[0], operator: "=", value: strings.TrimPrefix(parts[1], "=")}
+			case strings.HasPrefix(term, "!"):
+				req = labelRequirement{key: strings.TrimPrefix(term, "!"), operator: "!exists"}
+			default:
+				req = labelRequirement{key: term, operator: "exists"}
+			}
+			req.key = strings.TrimSpace(req.key)
+			req.value = strings.TrimSpace(req.value)
+			if err := validateLabelKey(req.key); err != nil {
+				return nil, fmt.Errorf("label selector '%s' is invalid: %s", expr, err)
+			}
+			selector = append(selector, req)
+		}
+	}
+	return selector, nil
+}
+
+// matches returns true if the labels meet all requirements of the selector
+func (selector labelSelector) matches(labels map[string]string) bool {
+	for _, req := range selector {
+		value, ok := labels[req.key]
+		switch req.operator {
+		case "=":
+			if !ok || value != req.value {
+				return false
+			}
+		case "!=":
+			if ok && value == req.value {
+				return false
+			}
+		case "exists":
+			if !ok {
+				return false
+			}
+		case "!exists":
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// selectInstances returns the service instances whose labels match the selectors
+func selectInstances(selectors []string) ([]*edenstore.FSServiceInstance, error) {
+	selector, err := parseLabelSelector(selectors)
+	if err != nil {
+		return nil, err
+	}
+	instances := []*edenstore.FSServiceInstance{}
+	for _, inst := range Opts.config().ServiceInstances() {
+		if selector.matches(inst.Labels) {
+			instances = append(instances, inst)
+		}
+	}
+	return instances, nil
+}
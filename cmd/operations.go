@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/starkandwayne/eden/apiclient"
+)
+
+// pollInterval is the time between last_operation requests for async operations
+var pollInterval = 5 * time.Second
+
+// waitForLastOperation polls the broker until an async operation of type
+// operationType (provision, update or deprovision) has finished, calling
+// report with each intermediate state. The instance being gone only means
+// success for deprovision.
+func waitForLastOperation(broker *apiclient.OpenServiceBroker, operationType, serviceID, planID, instanceID, operation string,
+	report func(*brokerapi.LastOperationResponse)) (*brokerapi.LastOperationResponse, error) {
+	// TODO: don't pollute brokerapi back into this level
+	lastOpResp := &brokerapi.LastOperationResponse{State: brokerapi.InProgress}
+	wait := pollInterval
+	for lastOpResp.State == brokerapi.InProgress {
+		time.Sleep(wait)
+		var err error
+		var retryAfter time.Duration
+		lastOpResp, retryAfter, err = broker.PollLastOperation(serviceID, planID, instanceID, operation)
+		if err == apiclient.ErrGone && operationType == "deprovision" {
+			lastOpResp = &brokerapi.LastOperationResponse{State: brokerapi.Succeeded, Description: "instance no longer exists"}
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if report != nil {
+			report(lastOpResp)
+		}
+		// the broker may ask for a different interval (OSB 2.15)
+		wait = pollInterval
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+	}
+	return lastOpResp, nil
+}
+
+// operationError is returned when an async operation did not succeed
+type operationError struct {
+	state       brokerapi.LastOperationState
+	description string
+}
+
+func (err *operationError) Error() string {
+	return fmt.Sprintf("operation %s: %s", err.state, err.description)
+}
+
+// lastOperationError returns an *operationError if an async operation did not succeed
+func lastOperationError(lastOpResp *brokerapi.LastOperationResponse) error {
+	if lastOpResp.State == brokerapi.Succeeded {
+		return nil
+	}
+	return &operationError{state: lastOpResp.State, description: lastOpResp.Description}
+}
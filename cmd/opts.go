@@ -1,8 +1,17 @@
 package cmd
 
 import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
+	"github.com/hashicorp/errwrap"
+	"github.com/starkandwayne/eden/apiclient"
 	edenstore "github.com/starkandwayne/eden/store"
 )
 
@@ -18,20 +27,31 @@ type BindingOpts struct {
 
 // BrokerOpts describes subset of flags/options for selecting target service broker API
 type BrokerOpts struct {
-	URLOpt          string `long:"url"           description:"Open Service Broker URL"                env:"SB_BROKER_URL" required:"true"`
-	ClientOpt       string `long:"client"        description:"Override username or UAA client"        env:"SB_BROKER_USERNAME" required:"true"`
-	ClientSecretOpt string `long:"client-secret" description:"Override password or UAA client secret" env:"SB_BROKER_PASSWORD" required:"true"`
-	APIVersion      string `long:"api-version"   description:"API version request to pass to backend broker" env:"SB_BROKER_API_VERSION" default:"2.13"`
+	URLOpt          string   `long:"url"           description:"Open Service Broker URL"                env:"SB_BROKER_URL" required:"true"`
+	ClientOpt       string   `long:"client"        description:"Override username or UAA client"        env:"SB_BROKER_USERNAME"`
+	ClientSecretOpt string   `long:"client-secret" description:"Override password or UAA client secret" env:"SB_BROKER_PASSWORD"`
+	APIVersion      string   `long:"api-version"   description:"API version request to pass to backend broker (default: negotiated with the broker)" env:"SB_BROKER_API_VERSION"`
+	AuthOpt         string   `long:"auth"          description:"Broker authentication method (default: that stored with 'target auth', or basic)" env:"SB_BROKER_AUTH" choice:"basic" choice:"bearer" choice:"oauth2"`
+	TokenOpt        string   `long:"token"         description:"Bearer token (--auth bearer)" env:"SB_BROKER_TOKEN"`
+	TokenURLOpt     string   `long:"token-url"     description:"UAA/OAuth2 token endpoint (--auth oauth2)" env:"SB_BROKER_TOKEN_URL"`
+	ScopesOpt       []string `long:"scope"         description:"OAuth2 scope to request, can be repeated (--auth oauth2)" env:"SB_BROKER_SCOPES" env-delim:","`
+	SecretFileOpt   string   `long:"secret-file"   description:"File holding the password, client secret or bearer token" env:"SB_BROKER_SECRET_FILE"`
 }
 
 // EdenOpts describes the flags/options for the CLI
 type EdenOpts struct {
-	Version bool `short:"v" long:"version" description:"Show version"`
+	Version bool `long:"version" description:"Show version"`
 
 	// Slice of bool will append 'true' each time the option
 	// is encountered (can be set multiple times, like -vvv)
-	Verbose []bool `long:"verbose" description:"Show verbose debug information" env:"EDEN_TRACE"`
-	JSON    bool   `long:"json" description:"Print information in JSON format, for easier parsing" env:"EDEN_AS_JSON"`
+	Verbose   []bool   `short:"v" long:"verbose" description:"Show broker requests (-v), including headers and bodies (-vv)" env:"EDEN_TRACE"`
+	TraceFile string   `long:"trace-file" description:"Write a HAR-like JSON log of all broker requests to file" env:"EDEN_TRACE_FILE"`
+	Record    string   `long:"record" description:"Record all broker requests and responses to a cassette file (appends to an existing one)" env:"EDEN_RECORD"`
+	Replay    string   `long:"replay" description:"Replay broker responses from a cassette file, without any network" env:"EDEN_REPLAY"`
+	Normalize []string `long:"normalize" description:"Regular expression of generated IDs to normalize in cassettes, in addition to UUIDs (can be repeated)" env:"EDEN_NORMALIZE"`
+	DryRun    string   `long:"dry-run" description:"Print the requests that would change the broker, as HTTP (default) or curl commands, instead of sending them; the config file is not changed" optional:"yes" optional-value:"http" choice:"http" choice:"curl" env:"EDEN_DRY_RUN"`
+	JSON      bool     `long:"json" description:"Print information in JSON format, for easier parsing (same as --output json)" env:"EDEN_AS_JSON"`
+	Output    string   `long:"output" description:"Output format: table, json, yaml or template=GO-TEMPLATE" env:"EDEN_OUTPUT" default:"table"`
 
 	ConfigPathOpt string `long:"config" description:"Config file path" env:"EDEN_CONFIG" default:"~/.eden/config"`
 
@@ -45,11 +65,26 @@ type EdenOpts struct {
 	Bind        BindOpts        `command:"bind" alias:"b" description:"Generate credentials for service instance"`
 	Unbind      UnbindOpts      `command:"unbind" alias:"u" description:"Remove credentials for service instance"`
 	Deprovision DeprovisionOpts `command:"deprovision" alias:"d" description:"Destroy service instance"`
+	Upgrade     UpgradeOpts     `command:"upgrade" description:"Upgrade service instances to the maintenance_info version of their plan"`
+	Rotate      RotateOpts      `command:"rotate" description:"Replace the bindings of a service instance with a new one"`
+	Apply       ApplyOpts       `command:"apply" description:"Provision, update, bind and deprovision to match a manifest"`
+	Doctor      DoctorOpts      `command:"doctor" description:"Check the service instances and bindings in the config file against their brokers"`
+	Conformance ConformanceOpts `command:"conformance" description:"Check that the target broker conforms to the Open Service Broker API"`
+	Smoke       SmokeOpts       `command:"smoke" description:"Provision, bind, unbind and deprovision each plan of the catalog"`
+	Bench       BenchOpts       `command:"bench" description:"Run a mix of broker operations concurrently and report their latencies"`
+	Curl        CurlOpts        `command:"curl" description:"Send an authenticated request to any path of the broker"`
+	Target      TargetOpts      `command:"target" description:"Show information about the target broker"`
+	Proxy       ProxyOpts       `command:"proxy" description:"Forward and log requests between a platform and a broker, optionally injecting failures"`
+	Cleanup     CleanupOpts     `command:"cleanup" description:"Unbind and deprovision old or matching service instances"`
 
 	// Local data commands
 	Services    ServicesOpts    `command:"services" alias:"s" description:"List service instances (stored in config file)"`
 	Credentials CredentialsOpts `command:"credentials" alias:"creds" alias:"c" description:"Display binding credentials (stored in config file)"`
 	Rename      RenameOpts      `command:"rename" description:"Rename service instance (stored in config file)"`
+	Protect     ProtectOpts     `command:"protect" description:"Protect service instance from deprovisioning (stored in config file)"`
+	Label       LabelOpts       `command:"label" description:"Show, add (key=value) or remove (key-) labels of a service instance (stored in config file)"`
+	Exec        ExecOpts        `command:"exec" description:"Run a command with binding credentials in its environment"`
+	Render      RenderOpts      `command:"render" description:"Render a config file template from binding credentials"`
 }
 
 // Opts carries all the user provided options (from flags or env vars)
@@ -66,6 +101,186 @@ func (opts EdenOpts) config() edenstore.FSConfig {
 	if err != nil {
 		panic(err)
 	}
+	if opts.DryRun != "" {
+		config.SetReadOnly()
+	}
 
 	return config
 }
+
+// configDir is the directory holding the config file, also used for other local state
+func (opts EdenOpts) configDir() string {
+	path, err := opts.fs().ExpandPath(opts.ConfigPathOpt)
+	if err != nil {
+		panic(err)
+	}
+	return filepath.Dir(path)
+}
+
+// brokerAuth returns the authentication settings for the target broker: those
+// stored with 'target auth', overridden by any flags or environment variables.
+// Choosing another --auth method than the stored one ignores the stored settings.
+func (opts EdenOpts) brokerAuth() edenstore.FSBrokerAuth {
+	auth := edenstore.FSBrokerAuth{}
+	stored := opts.config().FindBroker(opts.Broker.URLOpt).Auth
+	if stored != nil && (opts.Broker.AuthOpt == "" || opts.Broker.AuthOpt == stored.Type) {
+		auth = *stored
+	}
+	if opts.Broker.AuthOpt != "" {
+		auth.Type = opts.Broker.AuthOpt
+	}
+	if auth.Type == "" {
+		auth.Type = "basic"
+	}
+	if opts.Broker.ClientOpt != "" {
+		auth.Client = opts.Broker.ClientOpt
+	}
+	if opts.Broker.TokenURLOpt != "" {
+		auth.TokenURL = opts.Broker.TokenURLOpt
+	}
+	if len(opts.Broker.ScopesOpt) > 0 {
+		auth.Scopes = opts.Broker.ScopesOpt
+	}
+	if opts.Broker.SecretFileOpt != "" {
+		auth.SecretFile = opts.Broker.SecretFileOpt
+	}
+	return auth
+}
+
+// brokerSecret returns the password, client secret or bearer token from the
+// flags or environment variables, or else read from the secret file
+func (opts EdenOpts) brokerSecret(auth edenstore.FSBrokerAuth) (string, error) {
+	secret := opts.Broker.ClientSecretOpt
+	if auth.Type == "bearer" {
+		secret = opts.Broker.TokenOpt
+	}
+	if secret != "" || auth.SecretFile == "" {
+		return secret, nil
+	}
+	path, err := opts.fs().ExpandPath(auth.SecretFile)
+	if err != nil {
+		return "", errwrap.Wrapf("Failed to expand secret file path: {{err}}", err)
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errwrap.Wrapf("Failed to read secret file: {{err}}", err)
+	}
+	return strings.TrimSpace(string(contents)), nil
+}
+
+func (opts EdenOpts) authenticator() (apiclient.Authenticator, error) {
+	auth := opts.brokerAuth()
+	secret, err := opts.brokerSecret(auth)
+	if err != nil {
+		return nil, err
+	}
+	switch auth.Type {
+	case "bearer":
+		if secret == "" {
+			return nil, fmt.Errorf("--auth bearer requires --token or --secret-file, or $SB_BROKER_TOKEN")
+		}
+		return apiclient.BearerToken{Token: secret}, nil
+	case "oauth2":
+		if auth.TokenURL == "" {
+			return nil, fmt.Errorf("--auth oauth2 requires --token-url, or $SB_BROKER_TOKEN_URL")
+		}
+		if auth.Client == "" {
+			return nil, fmt.Errorf("--auth oauth2 requires --client, or $SB_BROKER_USERNAME")
+		}
+		return &apiclient.OAuth2ClientCredentials{
+			TokenURL:     auth.TokenURL,
+			ClientID:     auth.Client,
+			ClientSecret: secret,
+			Scopes:       auth.Scopes,
+			CacheDir:     filepath.Join(opts.configDir(), "tokens"),
+			HTTPClient:   &http.Client{Transport: opts.transport(), Timeout: apiclient.DefaultTimeout},
+		}, nil
+	default:
+		if auth.Client == "" || secret == "" {
+			return nil, fmt.Errorf("--auth basic requires --client and --client-secret or --secret-file, or $SB_BROKER_USERNAME and $SB_BROKER_PASSWORD")
+		}
+		return apiclient.BasicAuth{Username: auth.Client, Password: secret}, nil
+	}
+}
+
+// tracer is shared by all HTTP clients so that --trace-file covers the whole session
+var tracer *apiclient.Tracer
+
+func (opts EdenOpts) transport() http.RoundTripper {
+	if tracer == nil {
+		tracer = &apiclient.Tracer{
+			Verbosity: len(opts.Verbose),
+			Output:    os.Stderr,
+			TraceFile: opts.TraceFile,
+		}
+		switch {
+		case opts.Replay != "":
+			tracer.Transport = &apiclient.Cassette{Path: opts.Replay, Replay: true, Normalize: opts.Normalize}
+		case opts.Record != "":
+			tracer.Transport = &apiclient.Cassette{Path: opts.Record, Normalize: opts.Normalize}
+		}
+	}
+	return tracer
+}
+
+// broker constructs the API client for the target broker
+func (opts EdenOpts) broker() (*apiclient.OpenServiceBroker, error) {
+	return opts.brokerAt(opts.Broker.URLOpt)
+}
+
+// brokerAt constructs an API client for the broker at url, e.g. that of an
+// instance record. The authentication options are only valid for the target
+// broker, so other brokers are refused rather than sent its credentials.
+func (opts EdenOpts) brokerAt(url string) (*apiclient.OpenServiceBroker, error) {
+	if url == "" {
+		url = opts.Broker.URLOpt
+	}
+	if !opts.isTargetBroker(url) {
+		return nil, fmt.Errorf("%s is not the target broker %s; target it with --url to use its credentials", url, opts.Broker.URLOpt)
+	}
+	auth, err := opts.authenticator()
+	if err != nil {
+		return nil, err
+	}
+	broker := apiclient.NewOpenServiceBrokerWithAuth(url, auth, opts.Broker.APIVersion)
+	broker.SetTransport(opts.brokerTransport())
+	broker.SetPollInterval(pollInterval)
+	if opts.Broker.APIVersion == "" {
+		opts.negotiateAPIVersion(broker, url)
+	}
+	return broker, nil
+}
+
+// isTargetBroker returns true if url is that of the target broker; records
+// without a broker URL are assumed to be of the target broker
+func (opts EdenOpts) isTargetBroker(url string) bool {
+	return url == "" || strings.TrimSuffix(url, "/") == strings.TrimSuffix(opts.Broker.URLOpt, "/")
+}
+
+// negotiateAPIVersion uses the API version remembered for the broker at url,
+// or negotiates one; either way, if the broker later rejects the version, a
+// new one is negotiated and remembered. If the broker cannot be reached the
+// default version is used, so that the command reports the actual error.
+func (opts EdenOpts) negotiateAPIVersion(broker *apiclient.OpenServiceBroker, url string) {
+	broker.OnNegotiate(func(version string) {
+		if err := opts.config().RecordAPIVersion(url, version); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not remember API version %s of %s: %s\n", version, url, err)
+		}
+	})
+	if remembered := opts.config().FindBroker(url).APIVersion; remembered != "" {
+		broker.SetAPIVersion(remembered)
+		return
+	}
+	if _, err := broker.NegotiateAPIVersion(); err != nil {
+		broker.SetAPIVersion(apiclient.DefaultAPIVersion)
+	}
+}
+
+// brokerTransport is the transport for broker requests, which only prints
+// requests that would change the broker with --dry-run
+func (opts EdenOpts) brokerTransport() http.RoundTripper {
+	if opts.DryRun == "" {
+		return opts.transport()
+	}
+	return &apiclient.DryRun{Transport: opts.transport(), Output: os.Stdout, Curl: opts.DryRun == "curl"}
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/hashicorp/errwrap"
+	"gopkg.in/yaml.v2"
+)
+
+// outputFormat returns the --output format name, and the template text for
+// --output template=...; --json is shorthand for --output json
+func (opts EdenOpts) outputFormat() (format string, tmpl string) {
+	if opts.JSON {
+		return "json", ""
+	}
+	if strings.HasPrefix(opts.Output, "template=") {
+		return "template", strings.TrimPrefix(opts.Output, "template=")
+	}
+	if opts.Output == "" {
+		return "table", ""
+	}
+	return opts.Output, ""
+}
+
+// outputTemplate is the parsed --output template=...
+var outputTemplate *template.Template
+
+// ValidateOutput checks the --output format, and parses its template, before
+// a command runs, so that a bad format fails before anything is changed
+func (opts EdenOpts) ValidateOutput() error {
+	format, tmpl := opts.outputFormat()
+	switch format {
+	case "table", "json", "yaml":
+	case "template":
+		t, err := template.New("output").Funcs(templateFuncs).Parse(tmpl)
+		if err != nil {
+			return errwrap.Wrapf("Could not parse --output template: {{err}}", err)
+		}
+		outputTemplate = t
+	default:
+		return fmt.Errorf("Unknown --output format '%s'; use table, json, yaml or template=...", format)
+	}
+	return nil
+}
+
+// tableOutput is true if results should be printed as human readable tables/text
+func (opts EdenOpts) tableOutput() bool {
+	format, _ := opts.outputFormat()
+	return format == "table"
+}
+
+// progress prints progress messages for long running commands; they go to
+// stderr unless printing human readable output, so as not to corrupt json/yaml
+func progress(format string, args ...interface{}) {
+	if Opts.tableOutput() {
+		fmt.Printf(format, args...)
+	} else {
+		fmt.Fprintf(os.Stderr, format, args...)
+	}
+}
+
+// render prints the result of a command in the format selected by --output.
+// For table output the command specific printTable is used; json, yaml and
+// template outputs are derived from data. Templates are applied to each item
+// if data is a slice.
+func render(data interface{}, printTable func() error) error {
+	out := os.Stdout
+	format, _ := Opts.outputFormat()
+	switch format {
+	case "table":
+		return printTable()
+	case "json":
+		b, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return errwrap.Wrapf("Could not marshal output to JSON: {{err}}", err)
+		}
+		fmt.Fprintf(out, "%s\n", string(b))
+	case "yaml":
+		// round trip through JSON so that yaml keys match the json tags
+		b, err := json.Marshal(data)
+		if err != nil {
+			return errwrap.Wrapf("Could not marshal output to YAML: {{err}}", err)
+		}
+		var generic interface{}
+		if err = json.Unmarshal(b, &generic); err != nil {
+			return errwrap.Wrapf("Could not marshal output to YAML: {{err}}", err)
+		}
+		b, err = yaml.Marshal(generic)
+		if err != nil {
+			return errwrap.Wrapf("Could not marshal output to YAML: {{err}}", err)
+		}
+		fmt.Fprint(out, string(b))
+	case "template":
+		if outputTemplate == nil {
+			if err := Opts.ValidateOutput(); err != nil {
+				return err
+			}
+		}
+		t := outputTemplate
+		items := reflect.ValueOf(data)
+		if items.Kind() != reflect.Slice {
+			return executeTemplate(out, t, data)
+		}
+		for i := 0; i < items.Len(); i++ {
+			if err := executeTemplate(out, t, items.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("Unknown --output format '%s'; use table, json, yaml or template=...", format)
+	}
+	return nil
+}
+
+func executeTemplate(out io.Writer, t *template.Template, data interface{}) error {
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return errwrap.Wrapf("Could not render --output template: {{err}}", err)
+	}
+	result := b.String()
+	if !strings.HasSuffix(result, "\n") {
+		result += "\n"
+	}
+	fmt.Fprint(out, result)
+	return nil
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join": strings.Join,
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+)
+
+// parseParameters parses --parameters JSON, read from a file if prefixed with '@'
+func parseParameters(param string) (parameters json.RawMessage, err error) {
+	if len(param) == 0 {
+		return nil, nil
+	}
+	var input []byte
+	if strings.HasPrefix(param, "@") {
+		input, err = ioutil.ReadFile(param[1:])
+		if err != nil {
+			return nil, errwrap.Wrapf("Could not read file: {{err}}", err)
+		}
+	} else {
+		input = []byte(param)
+	}
+	if err := json.Unmarshal(input, &parameters); err != nil {
+		return nil, errwrap.Wrapf("Could not unmarshal parameters: {{err}}", err)
+	}
+	return parameters, nil
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// progressBoard shows the status of many concurrent operations. On a
+// terminal it redraws one line per operation in place; otherwise each
+// status change is printed as a progress message.
+type progressBoard struct {
+	mutex  sync.Mutex
+	names  []string
+	status map[string]string
+	width  int
+	live   bool
+	drawn  int
+}
+
+func newProgressBoard(names []string) *progressBoard {
+	board := &progressBoard{
+		names:  names,
+		status: map[string]string{},
+		live:   Opts.tableOutput() && isTerminal(os.Stdout),
+	}
+	for _, name := range names {
+		board.status[name] = "pending"
+		if len(name) > board.width {
+			board.width = len(name)
+		}
+	}
+	board.mutex.Lock()
+	defer board.mutex.Unlock()
+	board.draw()
+	return board
+}
+
+// set updates the status line of an operation
+func (board *progressBoard) set(name, format string, args ...interface{}) {
+	board.mutex.Lock()
+	defer board.mutex.Unlock()
+	status := fmt.Sprintf(format, args...)
+	if board.status[name] == status {
+		return
+	}
+	board.status[name] = status
+	if board.live {
+		board.draw()
+	} else {
+		progress("%-*s  %s\n", board.width, name, status)
+	}
+}
+
+func (board *progressBoard) draw() {
+	if !board.live {
+		return
+	}
+	if board.drawn > 0 {
+		// move the cursor back up to the first line of the board
+		fmt.Printf("\033[%dA", board.drawn)
+	}
+	for _, name := range board.names {
+		fmt.Printf("\033[2K%-*s  %s\n", board.width, name, board.status[name])
+	}
+	board.drawn = len(board.names)
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+)
+
+// ProtectOpts represents the 'protect' command
+type ProtectOpts struct {
+	Off bool `long:"off" description:"Remove the protection, allowing the instance to be deprovisioned"`
+}
+
+// protectResult is the structured output of the 'protect' command
+type protectResult struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Protected bool   `json:"protected"`
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c ProtectOpts) Execute(_ []string) (err error) {
+	instanceNameOrID := Opts.Instance.NameOrID
+	if instanceNameOrID == "" {
+		return fmt.Errorf("protect command requires --instance [NAME|GUID], or $SB_INSTANCE")
+	}
+	inst := Opts.config().FindServiceInstance(instanceNameOrID)
+	if inst.ServiceID == "" {
+		return fmt.Errorf("protect --instance '%s' was not found", instanceNameOrID)
+	}
+	if err = Opts.config().SetProtected(inst.ID, !c.Off); err != nil {
+		return err
+	}
+
+	result := protectResult{ID: inst.ID, Name: inst.Name, Protected: !c.Off}
+	return render(result, func() error {
+		if result.Protected {
+			fmt.Printf("protect: '%s' cannot be deprovisioned until 'protect --off'\n", result.Name)
+		} else {
+			fmt.Printf("protect: '%s' is no longer protected\n", result.Name)
+		}
+		return nil
+	})
+}
@@ -3,12 +3,12 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/errwrap"
+	"github.com/jhunt/go-table"
 	"github.com/pborman/uuid"
 	"github.com/pivotal-cf/brokerapi"
 	"github.com/starkandwayne/eden/apiclient"
@@ -16,19 +16,20 @@ import (
 
 // ProvisionOpts represents the 'provision' command
 type ProvisionOpts struct {
-	ServiceNameOrID string `short:"s" long:"service-name" description:"Service name/ID from catalog" required:"true"`
-	PlanNameOrID    string `short:"p" long:"plan-name" description:"Plan name/ID from catalog (default: first)"`
-	Parameters      string `short:"P" long:"parameters" description:"parameters in json format. To use a file as input, prepend the filename with '@' (-P=@data.json)"`
+	ServiceNameOrID string   `short:"s" long:"service-name" description:"Service name/ID from catalog" required:"true"`
+	PlanNameOrID    string   `short:"p" long:"plan-name" description:"Plan name/ID from catalog (default: first)"`
+	Parameters      string   `short:"P" long:"parameters" description:"parameters in json format. To use a file as input, prepend the filename with '@' (-P=@data.json)"`
+	Count           int      `short:"n" long:"count" description:"Number of instances to provision; names are suffixed with -1, -2, ..." default:"1"`
+	Parallel        int      `long:"parallel" description:"Maximum number of concurrent provisions with --count" default:"5"`
+	Labels          []string `long:"label" description:"Label the new instance(s) with KEY=VALUE (can be repeated)"`
 }
 
 // Execute is callback from go-flags.Commander interface
 func (c ProvisionOpts) Execute(_ []string) (err error) {
-	broker := apiclient.NewOpenServiceBroker(
-		Opts.Broker.URLOpt,
-		Opts.Broker.ClientOpt,
-		Opts.Broker.ClientSecretOpt,
-		Opts.Broker.APIVersion,
-	)
+	broker, err := Opts.broker()
+	if err != nil {
+		return err
+	}
 
 	service, err := broker.FindServiceByNameOrID(c.ServiceNameOrID)
 	if err != nil {
@@ -38,6 +39,17 @@ func (c ProvisionOpts) Execute(_ []string) (err error) {
 	if err != nil {
 		return errwrap.Wrapf("Could not find plan in service: {{err}}", err)
 	}
+	labels := map[string]string{}
+	for _, label := range c.Labels {
+		key, value, err := parseLabel(label)
+		if err != nil {
+			return err
+		}
+		labels[key] = value
+	}
+	if c.Count > 1 {
+		return c.provisionMany(broker, service, plan, labels)
+	}
 
 	instanceName := Opts.Instance.NameOrID
 	instanceID := uuid.New()
@@ -49,50 +61,244 @@ func (c ProvisionOpts) Execute(_ []string) (err error) {
 		return fmt.Errorf("Service instance '%s' already exists", instanceName)
 	}
 
-	var parameters json.RawMessage
-	if len(c.Parameters) > 0 {
-		var input []byte
-		if strings.HasPrefix(c.Parameters, "@") {
-			input, err = ioutil.ReadFile(c.Parameters[1:])
-			if err != nil {
-				return errwrap.Wrapf("Could not read file: {{err}}", err)
-			}
-		} else {
-			input = []byte(c.Parameters)
-		}
-		if err := json.Unmarshal(input, &parameters); err != nil {
-			return errwrap.Wrapf("Could not unmarshal parameters: {{err}}", err)
-		}
+	parameters, err := parseParameters(c.Parameters)
+	if err != nil {
+		return err
 	}
 	provisioningResp, isAsync, err := broker.Provision(service.ID, plan.ID, instanceID, parameters)
+	if apiclient.IsDryRun(err) {
+		return nil
+	}
 	if err != nil {
 		return errwrap.Wrapf("Failed to provision service instance: {{err}}", err)
 	}
-	Opts.config().ProvisionNewServiceInstance(instanceID, instanceName,
+	err = Opts.config().ProvisionNewServiceInstance(instanceID, instanceName,
 		service.ID, service.Name,
 		plan.ID, plan.Name,
 		Opts.Broker.URLOpt)
+	if err != nil {
+		return err
+	}
+	if len(parameters) > 0 {
+		if err = Opts.config().UpdateServiceInstance(instanceID, plan.ID, plan.Name, apiclient.RedactParameters(parameters)); err != nil {
+			return err
+		}
+	}
+	if len(labels) > 0 {
+		if err = Opts.config().SetLabels(instanceID, labels, nil); err != nil {
+			return err
+		}
+	}
+	if err = recordMaintenanceInfo(broker, instanceID, plan.ID); err != nil {
+		return err
+	}
 
-	fmt.Printf("provision:   %s/%s - name: %s\n", service.Name, plan.Name, instanceName)
+	result := provisionResult{
+		ID:          instanceID,
+		Name:        instanceName,
+		ServiceID:   service.ID,
+		ServiceName: service.Name,
+		PlanID:      plan.ID,
+		PlanName:    plan.Name,
+		BrokerURL:   Opts.Broker.URLOpt,
+		Async:       isAsync,
+		State:       string(brokerapi.Succeeded),
+	}
+	progress("provision:   %s/%s - name: %s\n", service.Name, plan.Name, instanceName)
+	var lastOpResp *brokerapi.LastOperationResponse
 	if isAsync {
-		fmt.Println("provision:   in-progress")
-		// TODO: don't pollute brokerapi back into this level
-		lastOpResp := &brokerapi.LastOperationResponse{State: brokerapi.InProgress}
-		for lastOpResp.State == brokerapi.InProgress {
-			time.Sleep(5 * time.Second)
-			lastOpResp, err = broker.LastOperation(service.ID, plan.ID, instanceID, provisioningResp.OperationData)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err.Error())
-				os.Exit(1)
+		progress("provision:   in-progress\n")
+		if err = Opts.config().RecordLastOperation(instanceID, "provision", string(brokerapi.InProgress), ""); err != nil {
+			return err
+		}
+		lastOpResp, err = waitForLastOperation(broker, "provision", service.ID, plan.ID, instanceID, provisioningResp.OperationData,
+			func(lastOpResp *brokerapi.LastOperationResponse) {
+				progress("provision:   %s - %s\n", lastOpResp.State, lastOpResp.Description)
+			})
+		if err != nil {
+			return err
+		}
+		result.State = string(lastOpResp.State)
+		result.Description = lastOpResp.Description
+	}
+	result.DashboardURL = provisioningResp.DashboardURL
+	if err = Opts.config().RecordLastOperation(instanceID, "provision", result.State, result.Description); err != nil {
+		return err
+	}
+	if lastOpResp != nil {
+		if err = lastOperationError(lastOpResp); err != nil {
+			return errwrap.Wrapf("Failed to provision service instance: {{err}}", err)
+		}
+	}
+
+	return render(result, func() error {
+		if result.DashboardURL == "" {
+			fmt.Println("provision:   done")
+		} else {
+			fmt.Printf("provision:   done - %s\n", result.DashboardURL)
+		}
+		return nil
+	})
+}
+
+// provisionResult is the structured output of the 'provision' command
+type provisionResult struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	ServiceID    string `json:"service_id"`
+	ServiceName  string `json:"service_name"`
+	PlanID       string `json:"plan_id"`
+	PlanName     string `json:"plan_name"`
+	BrokerURL    string `json:"broker_url"`
+	DashboardURL string `json:"dashboard_url,omitempty"`
+	Async        bool   `json:"async"`
+	State        string `json:"state"`
+	Description  string `json:"description,omitempty"`
+	Duration     string `json:"duration,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// provisionMany provisions --count instances concurrently, showing the progress
+// of each, followed by a summary
+func (c ProvisionOpts) provisionMany(broker *apiclient.OpenServiceBroker, service *brokerapi.Service, plan *brokerapi.ServicePlan, labels map[string]string) error {
+	parameters, err := parseParameters(c.Parameters)
+	if err != nil {
+		return err
+	}
+
+	results := make([]*provisionResult, c.Count)
+	names := make([]string, c.Count)
+	for i := range results {
+		id := uuid.New()
+		name := fmt.Sprintf("%s-%s-%s", service.Name, plan.Name, id)
+		if Opts.Instance.NameOrID != "" {
+			name = fmt.Sprintf("%s-%d", Opts.Instance.NameOrID, i+1)
+			if Opts.config().FindServiceInstance(name).ServiceName != "" {
+				return fmt.Errorf("Service instance '%s' already exists", name)
 			}
-			fmt.Printf("provision:   %s - %s\n", lastOpResp.State, lastOpResp.Description)
+		}
+		names[i] = name
+		results[i] = &provisionResult{
+			ID:          id,
+			Name:        name,
+			ServiceID:   service.ID,
+			ServiceName: service.Name,
+			PlanID:      plan.ID,
+			PlanName:    plan.Name,
+			BrokerURL:   Opts.Broker.URLOpt,
 		}
 	}
-	if provisioningResp.DashboardURL == "" {
-		fmt.Println("provision:   done")
-	} else {
-		fmt.Printf("provision:   done - %s\n", provisioningResp.DashboardURL)
+
+	if Opts.DryRun != "" {
+		for _, result := range results {
+			_, _, err = broker.Provision(result.ServiceID, result.PlanID, result.ID, parameters)
+			if err != nil && !apiclient.IsDryRun(err) {
+				return errwrap.Wrapf("Failed to provision service instance: {{err}}", err)
+			}
+		}
+		return nil
 	}
 
-	return
+	board := newProgressBoard(names)
+	parallel := c.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	work := make(chan *provisionResult)
+	wg := sync.WaitGroup{}
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for result := range work {
+				started := time.Now()
+				if err := c.provisionOne(broker, result, parameters, labels, board); err != nil {
+					result.State = string(brokerapi.Failed)
+					result.Error = err.Error()
+					board.set(result.Name, "failed - %s", err)
+				} else {
+					board.set(result.Name, "%s", result.State)
+				}
+				result.Duration = time.Since(started).Round(time.Second).String()
+			}
+		}()
+	}
+	for _, result := range results {
+		work <- result
+	}
+	close(work)
+	wg.Wait()
+
+	failed := 0
+	for _, result := range results {
+		if result.State != string(brokerapi.Succeeded) {
+			failed++
+		}
+	}
+	err = render(results, func() error {
+		fmt.Println("")
+		table := table.NewTable("Name", "Service/Plan", "State", "Duration", "Error")
+		for _, result := range results {
+			table.Row(nil, result.Name, result.ServiceName+"/"+result.PlanName, result.State, result.Duration, result.Error)
+		}
+		table.Output(os.Stdout)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("provision: %d of %d instances failed", failed, len(results))
+	}
+	return nil
+}
+
+func (c ProvisionOpts) provisionOne(broker *apiclient.OpenServiceBroker, result *provisionResult, parameters json.RawMessage, labels map[string]string, board *progressBoard) error {
+	board.set(result.Name, "provisioning")
+	provisioningResp, isAsync, err := broker.Provision(result.ServiceID, result.PlanID, result.ID, parameters)
+	if err != nil {
+		return err
+	}
+	err = Opts.config().ProvisionNewServiceInstance(result.ID, result.Name,
+		result.ServiceID, result.ServiceName, result.PlanID, result.PlanName, result.BrokerURL)
+	if err != nil {
+		return err
+	}
+	if len(parameters) > 0 {
+		if err = Opts.config().UpdateServiceInstance(result.ID, result.PlanID, result.PlanName, apiclient.RedactParameters(parameters)); err != nil {
+			return err
+		}
+	}
+	if len(labels) > 0 {
+		if err = Opts.config().SetLabels(result.ID, labels, nil); err != nil {
+			return err
+		}
+	}
+	if err = recordMaintenanceInfo(broker, result.ID, result.PlanID); err != nil {
+		return err
+	}
+	result.Async = isAsync
+	result.DashboardURL = provisioningResp.DashboardURL
+	result.State = string(brokerapi.Succeeded)
+	if !isAsync {
+		return Opts.config().RecordLastOperation(result.ID, "provision", result.State, "")
+	}
+
+	board.set(result.Name, "in progress")
+	if err = Opts.config().RecordLastOperation(result.ID, "provision", string(brokerapi.InProgress), ""); err != nil {
+		return err
+	}
+	lastOpResp, err := waitForLastOperation(broker, "provision", result.ServiceID, result.PlanID, result.ID, provisioningResp.OperationData,
+		func(lastOpResp *brokerapi.LastOperationResponse) {
+			board.set(result.Name, "%s - %s", lastOpResp.State, lastOpResp.Description)
+		})
+	if err != nil {
+		return err
+	}
+	result.State = string(lastOpResp.State)
+	result.Description = lastOpResp.Description
+	if err = Opts.config().RecordLastOperation(result.ID, "provision", result.State, result.Description); err != nil {
+		return err
+	}
+	return lastOperationError(lastOpResp)
 }
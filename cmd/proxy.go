@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+)
+
+// ProxyOpts represents the 'proxy' command
+type ProxyOpts struct {
+	Listen    string        `long:"listen" description:"Address to listen on for platform requests" default:":9000"`
+	Target    string        `long:"target" description:"URL of the broker to forward requests to (default: --url)"`
+	LogFile   string        `long:"log-file" description:"Also append each exchange to file as a line of JSON"`
+	Latency   time.Duration `long:"latency" description:"Delay matching requests by this long before forwarding them"`
+	ErrorRate float64       `long:"error-rate" description:"Fraction (0-1) of matching requests to answer with --error-status instead of forwarding them"`
+	Status    int           `long:"error-status" description:"HTTP status of injected error responses" default:"500"`
+	Only      []string      `long:"only" description:"Only inject latency/errors into this operation, e.g. provision or last_operation (can be repeated)"`
+}
+
+// osbExchange is a request/response between a platform and a broker, decoded into OSB terms
+type osbExchange struct {
+	Time        time.Time     `json:"time"`
+	Method      string        `json:"method"`
+	Path        string        `json:"path"`
+	Operation   string        `json:"operation"`
+	InstanceID  string        `json:"instance_id,omitempty"`
+	BindingID   string        `json:"binding_id,omitempty"`
+	ServiceID   string        `json:"service_id,omitempty"`
+	PlanID      string        `json:"plan_id,omitempty"`
+	APIVersion  string        `json:"api_version,omitempty"`
+	Async       bool          `json:"accepts_incomplete,omitempty"`
+	Status      int           `json:"status"`
+	OperationID string        `json:"operation_token,omitempty"`
+	State       string        `json:"state,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Duration    time.Duration `json:"duration_ns"`
+	Injected    string        `json:"injected,omitempty"`
+}
+
+// osbProxy forwards requests to a broker, logging and optionally disrupting them
+type osbProxy struct {
+	opts    ProxyOpts
+	reverse *httputil.ReverseProxy
+	mutex   sync.Mutex
+	logFile *os.File
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c ProxyOpts) Execute(_ []string) (err error) {
+	target := c.Target
+	if target == "" {
+		target = Opts.Broker.URLOpt
+	}
+	targetURL, err := url.Parse(target)
+	if err != nil || targetURL.Scheme == "" || targetURL.Host == "" {
+		return fmt.Errorf("proxy --target '%s' must be a URL, e.g. https://broker.example.com", target)
+	}
+	if c.ErrorRate < 0 || c.ErrorRate > 1 {
+		return fmt.Errorf("proxy --error-rate must be between 0 and 1")
+	}
+
+	proxy := &osbProxy{opts: c, reverse: httputil.NewSingleHostReverseProxy(targetURL)}
+	// send the target's host name, as brokers behind routers/load balancers expect
+	director := proxy.reverse.Director
+	proxy.reverse.Director = func(req *http.Request) {
+		director(req)
+		req.Host = targetURL.Host
+	}
+	if c.LogFile != "" {
+		proxy.logFile, err = os.OpenFile(c.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return errwrap.Wrapf("Could not open log file: {{err}}", err)
+		}
+		defer proxy.logFile.Close()
+	}
+
+	progress("proxy: listening on %s, forwarding to %s\n", c.Listen, target)
+	return http.ListenAndServe(c.Listen, proxy)
+}
+
+func (proxy *osbProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	started := time.Now()
+	body, _ := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	exchange := decodeOSBRequest(req, body)
+	exchange.Time = started
+
+	if proxy.disrupts(exchange.Operation) {
+		if proxy.opts.Latency > 0 {
+			time.Sleep(proxy.opts.Latency)
+			exchange.Injected = fmt.Sprintf("latency %s", proxy.opts.Latency)
+		}
+		if proxy.opts.ErrorRate > 0 && rand.Float64() < proxy.opts.ErrorRate {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(proxy.opts.Status)
+			fmt.Fprintf(w, `{"description":"error injected by eden proxy"}`)
+			exchange.Status = proxy.opts.Status
+			exchange.Injected = strings.TrimPrefix(exchange.Injected+fmt.Sprintf(", error %d", proxy.opts.Status), ", ")
+			exchange.Duration = time.Since(started)
+			proxy.log(exchange)
+			return
+		}
+	}
+
+	capture := &responseCapture{ResponseWriter: w, status: http.StatusOK}
+	proxy.reverse.ServeHTTP(capture, req)
+	exchange.Status = capture.status
+	exchange.Duration = time.Since(started)
+	decodeOSBResponse(exchange, capture.body.Bytes())
+	proxy.log(exchange)
+}
+
+// disrupts returns true if latency/errors should be injected into the operation
+func (proxy *osbProxy) disrupts(operation string) bool {
+	if proxy.opts.Latency == 0 && proxy.opts.ErrorRate == 0 {
+		return false
+	}
+	if len(proxy.opts.Only) == 0 {
+		return true
+	}
+	for _, only := range proxy.opts.Only {
+		if only == operation {
+			return true
+		}
+	}
+	return false
+}
+
+func (proxy *osbProxy) log(exchange *osbExchange) {
+	line, _ := json.Marshal(exchange)
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+	if proxy.logFile != nil {
+		proxy.logFile.Write(append(line, '\n'))
+	}
+	if !Opts.tableOutput() {
+		fmt.Println(string(line))
+		return
+	}
+
+	ids := []string{}
+	for _, field := range []struct{ name, value string }{
+		{"instance", exchange.InstanceID}, {"binding", exchange.BindingID},
+		{"service", exchange.ServiceID}, {"plan", exchange.PlanID},
+		{"operation", exchange.OperationID}, {"state", exchange.State}, {"error", exchange.Error},
+	} {
+		if field.value != "" {
+			ids = append(ids, field.name+"="+field.value)
+		}
+	}
+	if exchange.Async {
+		ids = append(ids, "accepts_incomplete")
+	}
+	if exchange.Injected != "" {
+		ids = append(ids, "injected="+exchange.Injected)
+	}
+	fmt.Printf("%s %-16s %d %s (%s)\n", exchange.Time.Format("15:04:05.000"), exchange.Operation,
+		exchange.Status, strings.Join(ids, " "), exchange.Duration.Round(time.Millisecond))
+	if exchange.Description != "" {
+		fmt.Printf("             %s\n", exchange.Description)
+	}
+}
+
+// decodeOSBRequest identifies the OSB operation, and the IDs it concerns, from a request
+func decodeOSBRequest(req *http.Request, body []byte) *osbExchange {
+	exchange := &osbExchange{
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		Operation:  "unknown",
+		APIVersion: req.Header.Get("X-Broker-Api-Version"),
+		Async:      req.URL.Query().Get("accepts_incomplete") == "true",
+	}
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	// allow for a broker URL with a path prefix
+	for len(parts) > 0 && parts[0] != "v2" {
+		parts = parts[1:]
+	}
+	if len(parts) >= 3 && parts[1] == "service_instances" {
+		exchange.InstanceID = parts[2]
+	}
+	if len(parts) >= 5 && parts[3] == "service_bindings" {
+		exchange.BindingID = parts[4]
+	}
+
+	switch {
+	case len(parts) == 2 && parts[1] == "catalog":
+		exchange.Operation = "catalog"
+	case len(parts) == 3 && exchange.InstanceID != "":
+		exchange.Operation = map[string]string{
+			"PUT": "provision", "PATCH": "update", "DELETE": "deprovision", "GET": "fetch_instance",
+		}[req.Method]
+	case len(parts) == 4 && parts[3] == "last_operation":
+		exchange.Operation = "last_operation"
+	case len(parts) == 5 && exchange.BindingID != "":
+		exchange.Operation = map[string]string{
+			"PUT": "bind", "DELETE": "unbind", "GET": "fetch_binding",
+		}[req.Method]
+	case len(parts) == 6 && parts[5] == "last_operation":
+		exchange.Operation = "binding_last_operation"
+	}
+	if exchange.Operation == "" {
+		exchange.Operation = "unknown"
+	}
+
+	query := req.URL.Query()
+	exchange.ServiceID = query.Get("service_id")
+	exchange.PlanID = query.Get("plan_id")
+	exchange.OperationID = query.Get("operation")
+	details := struct {
+		ServiceID string `json:"service_id"`
+		PlanID    string `json:"plan_id"`
+	}{}
+	if json.Unmarshal(body, &details) == nil {
+		if details.ServiceID != "" {
+			exchange.ServiceID = details.ServiceID
+		}
+		if details.PlanID != "" {
+			exchange.PlanID = details.PlanID
+		}
+	}
+	return exchange
+}
+
+// decodeOSBResponse adds the operation token, state and error of a response;
+// other fields, such as credentials, are deliberately not logged
+func decodeOSBResponse(exchange *osbExchange, body []byte) {
+	resp := struct {
+		Operation   string `json:"operation"`
+		State       string `json:"state"`
+		Error       string `json:"error"`
+		Description string `json:"description"`
+	}{}
+	if json.Unmarshal(body, &resp) != nil {
+		return
+	}
+	if resp.Operation != "" {
+		exchange.OperationID = resp.Operation
+	}
+	exchange.State = resp.State
+	exchange.Error = resp.Error
+	if exchange.Status >= 400 || exchange.State != "" {
+		exchange.Description = resp.Description
+	}
+}
+
+// responseCapture records the status and body of a response as it is written
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (capture *responseCapture) WriteHeader(status int) {
+	capture.status = status
+	capture.ResponseWriter.WriteHeader(status)
+}
+
+func (capture *responseCapture) Write(data []byte) (int, error) {
+	// the body is only decoded for OSB fields, so there is no need to keep large ones
+	if capture.body.Len() < 1<<20 {
+		capture.body.Write(data)
+	}
+	return capture.ResponseWriter.Write(data)
+}
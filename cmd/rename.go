@@ -2,6 +2,8 @@ package cmd
 
 import (
 	"fmt"
+
+	"github.com/hashicorp/errwrap"
 )
 
 // RenameOpts represents the 'rename' command
@@ -23,7 +25,19 @@ func (c RenameOpts) Execute(args []string) (err error) {
 	if inst.ServiceID == "" {
 		return fmt.Errorf("rename --instance '%s' was not found", instanceNameOrID)
 	}
-	fmt.Printf("Renaming '%s' to '%s'\n", inst.Name, newName)
-	Opts.config().RenameServiceInstance(instanceNameOrID, newName)
-	return
+	if err = Opts.config().RenameServiceInstance(instanceNameOrID, newName); err != nil {
+		return errwrap.Wrapf("Failed to rename service instance {{err}}", err)
+	}
+
+	result := renameResult{ID: inst.ID, OldName: inst.Name, NewName: newName}
+	return render(result, func() error {
+		fmt.Printf("Renaming '%s' to '%s'\n", result.OldName, result.NewName)
+		return nil
+	})
+}
+
+type renameResult struct {
+	ID      string `json:"id"`
+	OldName string `json:"old_name"`
+	NewName string `json:"new_name"`
 }
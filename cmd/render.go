@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/template"
+
+	"github.com/hashicorp/errwrap"
+	edenstore "github.com/starkandwayne/eden/store"
+)
+
+// RenderOpts represents the 'render' command
+type RenderOpts struct {
+	Template string `short:"t" long:"template" description:"Go text/template file to render" required:"true"`
+	Out      string `short:"o" long:"out" description:"File to write (default: stdout)"`
+	Mode     string `long:"mode" description:"File permissions of --out file" default:"0600"`
+}
+
+// renderInstance is the template data for a service instance and its most recent binding
+type renderInstance struct {
+	ID          string
+	Name        string
+	ServiceID   string
+	ServiceName string
+	PlanID      string
+	PlanName    string
+	BrokerURL   string
+	BindingID   string
+	BindingName string
+	Credentials map[string]interface{}
+}
+
+// renderData is the template data; the fields of the --instance (if any) are
+// available at the top level, e.g. {{.Credentials.uri}}, and every instance
+// by name via {{(instance "name").Credentials.uri}} or .Instances
+type renderData struct {
+	renderInstance
+	Instances map[string]renderInstance
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c RenderOpts) Execute(_ []string) (err error) {
+	mode, err := strconv.ParseUint(c.Mode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("render --mode '%s' must be octal, e.g. 0600", c.Mode)
+	}
+
+	data := renderData{Instances: map[string]renderInstance{}}
+	for _, inst := range Opts.config().ServiceInstances() {
+		item, err := newRenderInstance(*inst, "")
+		if err != nil {
+			return err
+		}
+		data.Instances[inst.Name] = item
+	}
+	if Opts.Instance.NameOrID != "" {
+		inst := Opts.config().FindServiceInstance(Opts.Instance.NameOrID)
+		if inst.ServiceID == "" {
+			return fmt.Errorf("render --instance '%s' was not found", Opts.Instance.NameOrID)
+		}
+		if len(inst.Bindings) == 0 {
+			return fmt.Errorf("render --instance '%s' has no bindings", Opts.Instance.NameOrID)
+		}
+		data.renderInstance, err = newRenderInstance(inst, Opts.Binding.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	text, err := ioutil.ReadFile(c.Template)
+	if err != nil {
+		return errwrap.Wrapf("Could not read template: {{err}}", err)
+	}
+	funcs := template.FuncMap{
+		"instance": func(name string) (renderInstance, error) {
+			if inst, ok := data.Instances[name]; ok {
+				return inst, nil
+			}
+			return renderInstance{}, fmt.Errorf("no service instance named '%s'", name)
+		},
+	}
+	for name, fn := range templateFuncs {
+		funcs[name] = fn
+	}
+	tmpl, err := template.New(filepath.Base(c.Template)).Funcs(funcs).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return errwrap.Wrapf("Could not parse template: {{err}}", err)
+	}
+	out := &bytes.Buffer{}
+	if err = tmpl.Execute(out, data); err != nil {
+		return errwrap.Wrapf("Could not render template: {{err}}", err)
+	}
+
+	if c.Out == "" {
+		_, err = os.Stdout.Write(out.Bytes())
+		return err
+	}
+	return writeFileAtomically(c.Out, out.Bytes(), os.FileMode(mode))
+}
+
+func newRenderInstance(inst edenstore.FSServiceInstance, bindingNameOrID string) (renderInstance, error) {
+	item := renderInstance{
+		ID:          inst.ID,
+		Name:        inst.Name,
+		ServiceID:   inst.ServiceID,
+		ServiceName: inst.ServiceName,
+		PlanID:      inst.PlanID,
+		PlanName:    inst.PlanName,
+		BrokerURL:   inst.BrokerURL,
+		Credentials: map[string]interface{}{},
+	}
+	if len(inst.Bindings) == 0 {
+		return item, nil
+	}
+	binding, err := selectBinding(inst, bindingNameOrID)
+	if err != nil {
+		return item, err
+	}
+	item.BindingID = binding.ID
+	item.BindingName = binding.Name
+	item.Credentials, err = binding.CredentialsJSON()
+	return item, err
+}
+
+// writeFileAtomically writes to a temporary file with the given permissions
+// and renames it over path, so the credentials are never readable by others
+// and consumers never see a partially written file
+func writeFileAtomically(path string, data []byte, mode os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return errwrap.Wrapf("Could not create output file: {{err}}", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return errwrap.Wrapf("Could not set output file permissions: {{err}}", err)
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return errwrap.Wrapf("Could not write output file: {{err}}", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return errwrap.Wrapf("Could not write output file: {{err}}", err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return errwrap.Wrapf("Could not write output file: {{err}}", err)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/pborman/uuid"
+	"github.com/starkandwayne/eden/apiclient"
+	edenstore "github.com/starkandwayne/eden/store"
+)
+
+// RotateOpts represents the 'rotate' command
+type RotateOpts struct {
+	Parameters string        `short:"P" long:"parameters" description:"parameters in json format for the new binding. To use a file as input, prepend the filename with '@' (-P=@data.json)"`
+	Hook       string        `long:"hook" description:"Shell command to deploy the new credentials; it runs with them in its environment, like 'eden exec'"`
+	Grace      time.Duration `long:"grace" description:"Wait this long before unbinding the old bindings, e.g. 10m"`
+	Yes        bool          `short:"y" long:"yes" description:"Unbind the old bindings without asking for confirmation"`
+}
+
+// rotateResult is the structured output of the 'rotate' command
+type rotateResult struct {
+	InstanceID   string   `json:"instance_id"`
+	InstanceName string   `json:"instance_name"`
+	BindingID    string   `json:"binding_id"`
+	BindingName  string   `json:"binding_name"`
+	Retired      []string `json:"retired_binding_ids"`
+	Retained     []string `json:"retained_binding_ids"`
+	Status       string   `json:"status"`
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c RotateOpts) Execute(_ []string) (err error) {
+	instanceNameOrID := Opts.Instance.NameOrID
+	if instanceNameOrID == "" {
+		return fmt.Errorf("rotate command requires --instance [NAME|GUID], or $SB_INSTANCE")
+	}
+	instance := Opts.config().FindServiceInstance(instanceNameOrID)
+	if instance.ServiceID == "" {
+		return fmt.Errorf("rotate --instance '%s' was not found", instanceNameOrID)
+	}
+	oldBindings := instance.Bindings
+
+	broker, err := Opts.broker()
+	if err != nil {
+		return err
+	}
+	parameters, err := parseParameters(c.Parameters)
+	if err != nil {
+		return err
+	}
+
+	bindingID := uuid.New()
+	bindingName := fmt.Sprintf("%s-%s", instance.ServiceName, bindingID)
+	progress("rotate: creating binding %s\n", bindingName)
+	bindingResp, err := broker.Bind(instance.ServiceID, instance.PlanID, instance.ID, bindingID, parameters)
+	if apiclient.IsDryRun(err) {
+		for _, binding := range oldBindings {
+			err = broker.Unbind(instance.ServiceID, instance.PlanID, instance.ID, binding.ID)
+			if err != nil && !apiclient.IsDryRun(err) {
+				return err
+			}
+		}
+		return nil
+	}
+	if err != nil {
+		return errwrap.Wrapf("Failed to bind to service instance {{err}}", err)
+	}
+	err = Opts.config().BindServiceInstance(instance.ID, bindingID, bindingName, bindingResp.Credentials, bindingDetails(bindingResp))
+	if err != nil {
+		return errwrap.Wrapf("Failed to store binding {{err}}", err)
+	}
+
+	result := rotateResult{
+		InstanceID:   instance.ID,
+		InstanceName: instance.Name,
+		BindingID:    bindingID,
+		BindingName:  bindingName,
+		Retired:      []string{},
+		Retained:     []string{},
+		Status:       "rotated",
+	}
+	for _, binding := range oldBindings {
+		result.Retained = append(result.Retained, binding.ID)
+	}
+
+	if c.Hook != "" {
+		progress("rotate: running hook '%s'\n", c.Hook)
+		if code := c.runHook(instance.ID, bindingID); code != 0 {
+			result.Status = "hook-failed"
+			if err = c.record(result); err != nil {
+				return err
+			}
+			return fmt.Errorf("rotate --hook exited with %d; the old bindings were kept, the new binding is '%s'", code, bindingName)
+		}
+	}
+
+	if len(oldBindings) > 0 {
+		retire := c.Yes || c.Grace > 0
+		if !retire {
+			retire = confirm(fmt.Sprintf("Unbind %d old binding(s) of '%s'?", len(oldBindings), instance.Name))
+		}
+		if !retire {
+			result.Status = "old-bindings-kept"
+			if err = c.record(result); err != nil {
+				return err
+			}
+			return render(result, func() error {
+				fmt.Println("rotate: old bindings were kept; use --yes or --grace to unbind them")
+				return nil
+			})
+		}
+		if c.Grace > 0 {
+			progress("rotate: waiting %s before unbinding old bindings\n", c.Grace)
+			time.Sleep(c.Grace)
+		}
+
+		result.Retained = []string{}
+		for _, binding := range oldBindings {
+			progress("rotate: unbinding %s\n", binding.Name)
+			if err := broker.Unbind(instance.ServiceID, instance.PlanID, instance.ID, binding.ID); err != nil {
+				fmt.Fprintf(os.Stderr, "rotate: failed to unbind %s: %s\n", binding.Name, err)
+				result.Retained = append(result.Retained, binding.ID)
+				result.Status = "partially-retired"
+				continue
+			}
+			if err = Opts.config().UnbindServiceInstance(instance.ID, binding.ID); err != nil {
+				return errwrap.Wrapf("Failed to remove binding from store {{err}}", err)
+			}
+			result.Retired = append(result.Retired, binding.ID)
+		}
+	}
+
+	if err = c.record(result); err != nil {
+		return err
+	}
+	err = render(result, func() error {
+		fmt.Println("rotate: done")
+		fmt.Println("")
+		fmt.Printf("Run 'eden credentials -i %s -b %s' to see the new credentials\n", instance.Name, bindingName)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(result.Retained) > 0 {
+		return fmt.Errorf("rotate: %d of %d old bindings could not be unbound", len(result.Retained), len(oldBindings))
+	}
+	return nil
+}
+
+// runHook runs the --hook command with the new credentials in its
+// environment; its output goes to stderr, to keep stdout for the result
+func (c RotateOpts) runHook(instanceID, bindingID string) int {
+	execOpts := ExecOpts{BindingID: bindingID}
+	env, err := execOpts.environment(Opts.config().FindServiceInstance(instanceID))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return runWithEnv([]string{"/bin/sh", "-c", c.Hook}, env, os.Stderr)
+}
+
+func (c RotateOpts) record(result rotateResult) error {
+	return Opts.config().RecordRotation(result.InstanceID, edenstore.FSRotation{
+		NewBindingID:       result.BindingID,
+		RetiredBindingIDs:  result.Retired,
+		RetainedBindingIDs: result.Retained,
+		Status:             result.Status,
+		RotatedAt:          time.Now(),
+	})
+}
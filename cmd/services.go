@@ -1,15 +1,27 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
+	"path"
+	"sort"
+	"time"
 
+	"github.com/hashicorp/errwrap"
 	"github.com/jhunt/go-table"
+	edenstore "github.com/starkandwayne/eden/store"
 )
 
 // ServicesOpts represents the 'services' command
 type ServicesOpts struct {
+	Selector   []string `short:"l" long:"label" description:"Only instances matching this label selector, e.g. -l env=staging,owner!=bob"`
+	Service    string   `long:"service" description:"Only instances of this service (name or ID)"`
+	Plan       string   `long:"plan" description:"Only instances of this plan (name or ID)"`
+	Broker     string   `long:"broker" description:"Only instances of this broker (URL or host name)"`
+	Name       string   `long:"name" description:"Only instances with names matching this glob, e.g. 'ci-*'"`
+	Sort       string   `long:"sort" description:"Sort instances by name or created_at (default: order created)" choice:"name" choice:"created_at"`
+	Wide       bool     `short:"w" long:"wide" description:"Show instance IDs, creation time, number of bindings and last operation"`
+	Upgradable bool     `long:"upgradable" description:"Only instances of the target broker whose plan has a newer maintenance_info version"`
 }
 
 // Execute is callback from go-flags.Commander interface
@@ -22,28 +34,74 @@ func (c ServicesOpts) Execute(_ []string) (err error) {
 }
 
 func (c ServicesOpts) showAllServices() (err error) {
-	if Opts.JSON {
-		b, err := json.Marshal(Opts.config().ServiceInstances())
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err.Error())
-			os.Exit(1)
-		}
-		fmt.Printf("%s\n", string(b))
-		os.Exit(0)
+	instances, err := c.instances()
+	if err != nil {
+		return err
 	}
+	if c.Upgradable {
+		return c.showUpgradable(instances)
+	}
+	return render(instances, func() error {
+		if c.Wide {
+			table := table.NewTable("Name", "ID", "Service", "Plan", "Bindings", "Created", "Age", "Last Operation", "Labels", "Broker URL")
+			for _, inst := range instances {
+				table.Row(nil, inst.Name, inst.ID, inst.ServiceName, inst.PlanName, fmt.Sprintf("%d", len(inst.Bindings)),
+					inst.CreatedAt.Local().Format(time.RFC3339), time.Since(inst.CreatedAt).Round(time.Second).String(),
+					lastOperationState(inst.LastOperation), formatLabels(inst.Labels), inst.BrokerURL)
+			}
+			table.Output(os.Stdout)
+			return nil
+		}
+		table := table.NewTable("Name", "Service", "Plan", "Binding", "Expires", "Labels", "Broker URL")
+		for _, inst := range instances {
+			bindingName := "n/a"
+			if len(inst.Bindings) > 0 {
+				bindingName = inst.Bindings[0].Name
+			}
+			table.Row(nil, inst.Name, inst.ServiceName, inst.PlanName, bindingName, nextExpiry(inst.Bindings), formatLabels(inst.Labels), inst.BrokerURL)
+		}
+		table.Output(os.Stdout)
+		return nil
+	})
+}
 
-	table := table.NewTable("Name", "Service", "Plan", "Binding", "Broker URL")
-
-	instances := Opts.config().ServiceInstances()
-	for _, inst := range instances {
-		bindingName := "n/a"
-		if len(inst.Bindings) > 0 {
-			bindingName = inst.Bindings[0].Name
+// instances returns the service instances matching the filters, sorted by --sort
+func (c ServicesOpts) instances() ([]*edenstore.FSServiceInstance, error) {
+	if c.Name != "" {
+		if _, err := path.Match(c.Name, ""); err != nil {
+			return nil, fmt.Errorf("services --name '%s' is not a valid glob: %s", c.Name, err)
 		}
-		table.Row(nil, inst.Name, inst.ServiceName, inst.PlanName, bindingName, inst.BrokerURL)
 	}
-	table.Output(os.Stdout)
-	return
+	selected, err := selectInstances(c.Selector)
+	if err != nil {
+		return nil, err
+	}
+	instances := []*edenstore.FSServiceInstance{}
+	for _, inst := range selected {
+		if c.Service != "" && c.Service != inst.ServiceName && c.Service != inst.ServiceID {
+			continue
+		}
+		if c.Plan != "" && c.Plan != inst.PlanName && c.Plan != inst.PlanID {
+			continue
+		}
+		if c.Broker != "" && !matchesBroker(inst.BrokerURL, c.Broker) {
+			continue
+		}
+		if c.Name != "" {
+			if matched, _ := path.Match(c.Name, inst.Name); !matched {
+				continue
+			}
+		}
+		instances = append(instances, inst)
+	}
+
+	switch c.Sort {
+	case "name":
+		sort.SliceStable(instances, func(i, j int) bool { return instances[i].Name < instances[j].Name })
+	case "created_at":
+		sort.SliceStable(instances, func(i, j int) bool { return instances[i].CreatedAt.Before(instances[j].CreatedAt) })
+	}
+	return instances, nil
 }
 
 func (c ServicesOpts) showService(instanceNameOrID string) (err error) {
@@ -51,15 +109,110 @@ func (c ServicesOpts) showService(instanceNameOrID string) (err error) {
 	if inst.ServiceID == "" {
 		return fmt.Errorf("services --instance '%s' was not found", instanceNameOrID)
 	}
-	fmt.Printf("Instance Name: %s\n", inst.Name)
-	fmt.Printf("Service/Plan:  %s/%s\n", inst.ServiceName, inst.PlanName)
-	if len(inst.Bindings) > 0 {
-		fmt.Println("Bindings:")
-		for _, binding := range inst.Bindings {
-			fmt.Printf("- %s\n", binding.Name)
-		}
-	} else {
-		fmt.Println("No bindings.")
+	return render(inst, func() error {
+		fmt.Printf("Instance Name:  %s\n", inst.Name)
+		fmt.Printf("Instance ID:    %s\n", inst.ID)
+		fmt.Printf("Service:        %s (%s)\n", inst.ServiceName, inst.ServiceID)
+		fmt.Printf("Plan:           %s (%s)\n", inst.PlanName, inst.PlanID)
+		fmt.Printf("Broker URL:     %s\n", inst.BrokerURL)
+		fmt.Printf("Created:        %s (%s ago)\n", inst.CreatedAt.Local().Format(time.RFC3339), time.Since(inst.CreatedAt).Round(time.Second))
+		if op := inst.LastOperation; op != nil {
+			description := ""
+			if op.Description != "" {
+				description = " - " + op.Description
+			}
+			fmt.Printf("Last Operation: %s%s (%s)\n", lastOperationState(op), description, op.UpdatedAt.Local().Format(time.RFC3339))
+		}
+		if len(inst.Labels) > 0 {
+			fmt.Printf("Labels:         %s\n", formatLabels(inst.Labels))
+		}
+		if inst.Protected {
+			fmt.Println("Protected:      yes")
+		}
+		if inst.MaintenanceInfo != nil {
+			description := ""
+			if inst.MaintenanceInfo.Description != "" {
+				description = " - " + inst.MaintenanceInfo.Description
+			}
+			fmt.Printf("Maintenance:    %s%s\n", inst.MaintenanceInfo.Version, description)
+		}
+		if inst.Parameters != "" {
+			fmt.Printf("Parameters:     %s\n", inst.Parameters)
+		}
+		if len(inst.Bindings) > 0 {
+			fmt.Println("Bindings:")
+			table := table.NewTable("Name", "ID", "Created", "Expires")
+			for _, binding := range inst.Bindings {
+				expires := "n/a"
+				if binding.ExpiresAt != nil {
+					expires = binding.ExpiresAt.Local().Format(time.RFC3339)
+				}
+				table.Row(nil, binding.Name, binding.ID, binding.CreatedAt.Local().Format(time.RFC3339), expires)
+			}
+			table.Output(os.Stdout)
+		} else {
+			fmt.Println("No bindings.")
+		}
+		return nil
+	})
+}
+
+// showUpgradable lists the instances of the target broker whose plan has a
+// newer maintenance_info version, which 'eden upgrade' would upgrade them to
+func (c ServicesOpts) showUpgradable(instances []*edenstore.FSServiceInstance) error {
+	upgrades, err := availableUpgrades(instances)
+	if err != nil {
+		return errwrap.Wrapf("Could not check for upgrades: {{err}}", err)
+	}
+	upgradable := []*upgradeResult{}
+	for _, inst := range instances {
+		available, ok := upgrades[inst.ID]
+		if !ok {
+			continue
+		}
+		result := &upgradeResult{ID: inst.ID, Name: inst.Name, ServiceName: inst.ServiceName, PlanName: inst.PlanName,
+			To: available.Version, Status: "upgradable"}
+		if inst.MaintenanceInfo != nil {
+			result.From = inst.MaintenanceInfo.Version
+		}
+		upgradable = append(upgradable, result)
+	}
+	return render(upgradable, func() error {
+		if len(upgradable) == 0 {
+			fmt.Println("services: no instances have upgrades available")
+			return nil
+		}
+		table := table.NewTable("Name", "Service", "Plan", "Version", "Available")
+		for _, result := range upgradable {
+			from := result.From
+			if from == "" {
+				from = "n/a"
+			}
+			table.Row(nil, result.Name, result.ServiceName, result.PlanName, from, result.To)
+		}
+		table.Output(os.Stdout)
+		return nil
+	})
+}
+
+// lastOperationState shows the type and state of an instance's last operation
+func lastOperationState(op *edenstore.FSLastOperation) string {
+	if op == nil {
+		return "n/a"
+	}
+	return fmt.Sprintf("%s %s", op.Type, op.State)
+}
+
+// nextExpiry shows the earliest expiry of the bindings, if any expire
+func nextExpiry(bindings []edenstore.FSServiceBinding) string {
+	var next *time.Time
+	for _, binding := range bindings {
+		if binding.ExpiresAt != nil && (next == nil || binding.ExpiresAt.Before(*next)) {
+			next = binding.ExpiresAt
+		}
+	}
+	if next == nil {
+		return "n/a"
 	}
-	return
+	return next.Local().Format(time.RFC3339)
 }
@@ -0,0 +1,290 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/jhunt/go-table"
+	"github.com/pborman/uuid"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/starkandwayne/eden/apiclient"
+	"gopkg.in/yaml.v2"
+)
+
+// SmokeOpts represents the 'smoke' command
+type SmokeOpts struct {
+	Service        string        `long:"service" description:"Only services with names matching this glob" default:"*"`
+	Plan           string        `long:"plan" description:"Only plans with names matching this glob" default:"*"`
+	ParametersFile string        `long:"parameters-file" description:"YAML/JSON file of provision parameters, keyed by 'service/plan' or 'service'"`
+	JUnit          string        `long:"junit" description:"Write a JUnit XML report to file"`
+	Parallel       int           `long:"parallel" description:"Maximum number of plans to test concurrently" default:"1"`
+	Timeout        time.Duration `long:"timeout" description:"Maximum time to wait for each async operation" default:"30m"`
+}
+
+// smokeResult is the outcome of the lifecycle of one plan
+type smokeResult struct {
+	ServiceName string      `json:"service_name"`
+	PlanName    string      `json:"plan_name"`
+	InstanceID  string      `json:"instance_id"`
+	Status      string      `json:"status"`
+	Steps       []*testCase `json:"steps"`
+	service     *brokerapi.Service
+	plan        *brokerapi.ServicePlan
+	parameters  json.RawMessage
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c SmokeOpts) Execute(_ []string) (err error) {
+	for _, glob := range []string{c.Service, c.Plan} {
+		if _, err = path.Match(glob, ""); err != nil {
+			return fmt.Errorf("smoke: '%s' is not a valid glob: %s", glob, err)
+		}
+	}
+	parameters, err := c.parameters()
+	if err != nil {
+		return err
+	}
+	broker, err := Opts.broker()
+	if err != nil {
+		return err
+	}
+	catalog, err := broker.Catalog()
+	if err != nil {
+		return errwrap.Wrapf("Could not fetch catalog: {{err}}", err)
+	}
+
+	results := []*smokeResult{}
+	for i := range catalog.Services {
+		service := &catalog.Services[i]
+		if matched, _ := path.Match(c.Service, service.Name); !matched {
+			continue
+		}
+		for j := range service.Plans {
+			plan := &service.Plans[j]
+			if matched, _ := path.Match(c.Plan, plan.Name); !matched {
+				continue
+			}
+			if !planBindable(service, plan) {
+				continue
+			}
+			params, ok := parameters[service.Name+"/"+plan.Name]
+			if !ok {
+				params = parameters[service.Name]
+			}
+			results = append(results, &smokeResult{
+				ServiceName: service.Name,
+				PlanName:    plan.Name,
+				InstanceID:  uuid.New(),
+				service:     service,
+				plan:        plan,
+				parameters:  params,
+			})
+		}
+	}
+	if len(results) == 0 {
+		return fmt.Errorf("smoke: no bindable plans match --service '%s' --plan '%s'", c.Service, c.Plan)
+	}
+
+	c.runAll(broker, results)
+
+	cases := []*testCase{}
+	failed := 0
+	for _, result := range results {
+		cases = append(cases, result.Steps...)
+		if result.Status == testFailed {
+			failed++
+		}
+	}
+	if c.JUnit != "" {
+		if err = writeJUnit(c.JUnit, cases); err != nil {
+			return err
+		}
+	}
+	err = render(results, func() error {
+		table := table.NewTable("Service/Plan", "Provision", "Bind", "Unbind", "Deprovision", "Result")
+		for _, result := range results {
+			row := []interface{}{result.ServiceName + "/" + result.PlanName}
+			for _, step := range result.Steps {
+				switch step.Status {
+				case testPassed:
+					row = append(row, step.Duration.Round(time.Millisecond).String())
+				default:
+					row = append(row, step.Status)
+				}
+			}
+			table.Row(nil, append(row, result.Status)...)
+		}
+		table.Output(os.Stdout)
+		for _, result := range results {
+			for _, step := range result.Steps {
+				if step.Status == testFailed {
+					fmt.Printf("%s %s: %s\n", step.Suite, step.Name, step.Message)
+				}
+			}
+		}
+		fmt.Printf("\n%d of %d plans passed\n", len(results)-failed, len(results))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("smoke: %d of %d plans failed", failed, len(results))
+	}
+	return nil
+}
+
+// parameters reads --parameters-file
+func (c SmokeOpts) parameters() (map[string]json.RawMessage, error) {
+	parameters := map[string]json.RawMessage{}
+	if c.ParametersFile == "" {
+		return parameters, nil
+	}
+	bytes, err := ioutil.ReadFile(c.ParametersFile)
+	if err != nil {
+		return nil, errwrap.Wrapf("Could not read parameters file: {{err}}", err)
+	}
+	file := map[string]map[string]interface{}{}
+	if err = yaml.Unmarshal(bytes, &file); err != nil {
+		return nil, errwrap.Wrapf("Could not parse parameters file: {{err}}", err)
+	}
+	for key, params := range file {
+		if parameters[key], err = manifestParameters(params); err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("Parameters for '%s': {{err}}", key), err)
+		}
+	}
+	return parameters, nil
+}
+
+// planBindable applies the plan's bindable override of its service
+func planBindable(service *brokerapi.Service, plan *brokerapi.ServicePlan) bool {
+	if plan.Bindable != nil {
+		return *plan.Bindable
+	}
+	return service.Bindable
+}
+
+// runAll runs the lifecycle of each plan, up to --parallel at a time
+func (c SmokeOpts) runAll(broker *apiclient.OpenServiceBroker, results []*smokeResult) {
+	parallel := c.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	work := make(chan *smokeResult)
+	wg := sync.WaitGroup{}
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for result := range work {
+				c.run(broker, result)
+			}
+		}()
+	}
+	for _, result := range results {
+		work <- result
+	}
+	close(work)
+	wg.Wait()
+}
+
+// run provisions, binds, unbinds and deprovisions an instance of a plan; the
+// instance is deprovisioned even if binding fails
+func (c SmokeOpts) run(broker *apiclient.OpenServiceBroker, result *smokeResult) {
+	suite := result.ServiceName + "/" + result.PlanName
+	step := func(name string, fn func() error) bool {
+		progress("smoke: %s %s\n", suite, name)
+		started := time.Now()
+		err := fn()
+		tc := &testCase{Suite: suite, Name: name, Status: testPassed, Duration: time.Since(started)}
+		if err != nil {
+			tc.Status = testFailed
+			tc.Message = err.Error()
+			progress("smoke: %s %s failed: %s\n", suite, name, err)
+		}
+		result.Steps = append(result.Steps, tc)
+		return err == nil
+	}
+	skip := func(name, reason string) {
+		result.Steps = append(result.Steps, &testCase{Suite: suite, Name: name, Status: testSkipped, Message: reason})
+	}
+	serviceID, planID, instanceID := result.service.ID, result.plan.ID, result.InstanceID
+
+	created := false
+	provisioned := step("provision", func() error {
+		resp, isAsync, err := broker.Provision(serviceID, planID, instanceID, result.parameters)
+		if err != nil {
+			return err
+		}
+		created = true
+		if isAsync {
+			return c.wait(broker, result, "provision", resp.OperationData)
+		}
+		return nil
+	})
+
+	bindingID := uuid.New()
+	if provisioned && step("bind", func() error {
+		_, err := broker.Bind(serviceID, planID, instanceID, bindingID, nil)
+		return err
+	}) {
+		step("unbind", func() error {
+			return broker.Unbind(serviceID, planID, instanceID, bindingID)
+		})
+	} else {
+		if !provisioned {
+			skip("bind", "provision failed")
+		}
+		skip("unbind", "bind failed")
+	}
+
+	if created {
+		step("deprovision", func() error {
+			resp, isAsync, err := broker.Deprovision(serviceID, planID, instanceID)
+			if err != nil {
+				return err
+			}
+			if isAsync {
+				return c.wait(broker, result, "deprovision", resp.OperationData)
+			}
+			return nil
+		})
+	} else {
+		skip("deprovision", "provision failed")
+	}
+
+	result.Status = testPassed
+	for _, tc := range result.Steps {
+		if tc.Status != testPassed {
+			result.Status = testFailed
+		}
+	}
+}
+
+// wait polls last_operation until the operation finishes or --timeout passes
+func (c SmokeOpts) wait(broker *apiclient.OpenServiceBroker, result *smokeResult, operationType, operation string) error {
+	type outcome struct {
+		lastOpResp *brokerapi.LastOperationResponse
+		err        error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		lastOpResp, err := waitForLastOperation(broker, operationType, result.service.ID, result.plan.ID, result.InstanceID, operation, nil)
+		done <- outcome{lastOpResp, err}
+	}()
+	select {
+	case o := <-done:
+		if o.err != nil {
+			return o.err
+		}
+		return lastOperationError(o.lastOpResp)
+	case <-time.After(c.Timeout):
+		return fmt.Errorf("operation did not finish within %s", c.Timeout)
+	}
+}
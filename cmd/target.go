@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/jhunt/go-table"
+	"github.com/starkandwayne/eden/apiclient"
+	edenstore "github.com/starkandwayne/eden/store"
+)
+
+// TargetOpts represents the 'target' command
+type TargetOpts struct {
+	Info TargetInfoOpts `command:"info" description:"Show the OSB API version of the target broker and the features it supports"`
+	Auth TargetAuthOpts `command:"auth" description:"Store or show how to authenticate with the target broker"`
+}
+
+// TargetAuthOpts represents the 'target auth' command
+type TargetAuthOpts struct {
+	Unset bool `long:"unset" description:"Forget the stored authentication settings of the target broker"`
+}
+
+// TargetInfoOpts represents the 'target info' command
+type TargetInfoOpts struct {
+	Refresh bool `long:"refresh" description:"Negotiate the API version again, rather than using the remembered one"`
+}
+
+// targetInfo is the structured output of the 'target info' command
+type targetInfo struct {
+	URL          string          `json:"url"`
+	Auth         string          `json:"auth"`
+	APIVersion   string          `json:"api_version"`
+	Source       string          `json:"api_version_source"`
+	NegotiatedAt *time.Time      `json:"negotiated_at,omitempty"`
+	Features     []targetFeature `json:"features"`
+	Services     []targetService `json:"services"`
+	CatalogError string          `json:"catalog_error,omitempty"`
+}
+
+type targetFeature struct {
+	apiclient.Feature
+	Supported bool `json:"supported"`
+}
+
+// targetService shows whether the GET endpoints can be used for a service,
+// which the catalog has to opt in to
+type targetService struct {
+	Name                 string `json:"name"`
+	InstancesRetrievable bool   `json:"instances_retrievable"`
+	BindingsRetrievable  bool   `json:"bindings_retrievable"`
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c TargetInfoOpts) Execute(_ []string) (err error) {
+	url := Opts.Broker.URLOpt
+	remembered := Opts.config().FindBroker(url)
+	broker, err := Opts.broker()
+	if err != nil {
+		return err
+	}
+
+	info := targetInfo{URL: url, Auth: Opts.brokerAuth().Type}
+	switch {
+	case Opts.Broker.APIVersion != "":
+		info.Source = "--api-version"
+	case remembered.APIVersion != "" && !c.Refresh:
+		info.Source = "remembered"
+	default:
+		// brokerAt has only negotiated if no version was remembered
+		if c.Refresh || Opts.config().FindBroker(url).APIVersion == "" {
+			if _, err = broker.NegotiateAPIVersion(); err != nil {
+				broker.SetAPIVersion(apiclient.DefaultAPIVersion)
+				info.Source = fmt.Sprintf("default, as negotiation failed: %s", err)
+				break
+			}
+		}
+		info.Source = "negotiated"
+	}
+	if info.Source == "remembered" || info.Source == "negotiated" {
+		negotiatedAt := Opts.config().FindBroker(url).NegotiatedAt
+		if !negotiatedAt.IsZero() {
+			info.NegotiatedAt = &negotiatedAt
+		}
+	}
+	info.APIVersion = broker.APIVersion()
+	for _, feature := range apiclient.Features {
+		info.Features = append(info.Features, targetFeature{Feature: feature, Supported: broker.Supports(feature)})
+	}
+	if err = info.addServices(broker); err != nil {
+		info.CatalogError = err.Error()
+	}
+
+	return render(info, func() error {
+		fmt.Printf("URL:          %s\n", info.URL)
+		fmt.Printf("Auth:         %s\n", info.Auth)
+		fmt.Printf("API version:  %s (%s)\n", info.APIVersion, info.Source)
+		if info.NegotiatedAt != nil {
+			fmt.Printf("Negotiated:   %s\n", info.NegotiatedAt.Local().Format(time.RFC1123))
+		}
+		fmt.Println("")
+		features := table.NewTable("Feature", "Requires", "Supported")
+		for _, feature := range info.Features {
+			features.Row(nil, feature.Name, feature.MinVersion, yesNo(feature.Supported))
+		}
+		features.Output(os.Stdout)
+		fmt.Println("")
+		if info.CatalogError != "" {
+			fmt.Printf("Could not fetch the catalog: %s\n", info.CatalogError)
+			return nil
+		}
+		services := table.NewTable("Service", "Instances retrievable", "Bindings retrievable")
+		for _, service := range info.Services {
+			services.Row(nil, service.Name, yesNo(service.InstancesRetrievable), yesNo(service.BindingsRetrievable))
+		}
+		services.Output(os.Stdout)
+		return nil
+	})
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c TargetAuthOpts) Execute(_ []string) (err error) {
+	url := Opts.Broker.URLOpt
+	switch {
+	case c.Unset:
+		if err = Opts.config().RecordBrokerAuth(url, nil); err != nil {
+			return errwrap.Wrapf("Failed to forget broker auth: {{err}}", err)
+		}
+	case Opts.Broker.AuthOpt != "":
+		auth := Opts.brokerAuth()
+		if auth.Type == "oauth2" && auth.TokenURL == "" {
+			return fmt.Errorf("--auth oauth2 requires --token-url, or $SB_BROKER_TOKEN_URL")
+		}
+		if err = Opts.config().RecordBrokerAuth(url, &auth); err != nil {
+			return errwrap.Wrapf("Failed to store broker auth: {{err}}", err)
+		}
+	}
+
+	auth := Opts.config().FindBroker(url).Auth
+	if auth == nil {
+		auth = &edenstore.FSBrokerAuth{}
+	}
+	return render(auth, func() error {
+		if auth.Type == "" {
+			fmt.Printf("No authentication settings stored for %s; using flags and environment variables\n", url)
+			return nil
+		}
+		fmt.Printf("URL:          %s\n", url)
+		fmt.Printf("Auth:         %s\n", auth.Type)
+		if auth.Client != "" {
+			fmt.Printf("Client:       %s\n", auth.Client)
+		}
+		if auth.TokenURL != "" {
+			fmt.Printf("Token URL:    %s\n", auth.TokenURL)
+		}
+		if len(auth.Scopes) > 0 {
+			fmt.Printf("Scopes:       %s\n", strings.Join(auth.Scopes, ", "))
+		}
+		if auth.SecretFile != "" {
+			fmt.Printf("Secret file:  %s\n", auth.SecretFile)
+		}
+		return nil
+	})
+}
+
+// addServices adds the retrievable flags of each service in the catalog
+func (info *targetInfo) addServices(broker *apiclient.OpenServiceBroker) error {
+	catalog, err := broker.Catalog()
+	if err != nil {
+		return err
+	}
+	for _, service := range catalog.Services {
+		instances, err := broker.InstancesRetrievable(service.ID)
+		if err != nil {
+			return err
+		}
+		bindings, err := broker.BindingsRetrievable(service.ID)
+		if err != nil {
+			return err
+		}
+		info.Services = append(info.Services, targetService{Name: service.Name, InstancesRetrievable: instances, BindingsRetrievable: bindings})
+	}
+	return nil
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
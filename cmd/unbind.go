@@ -23,18 +23,30 @@ func (c UnbindOpts) Execute(_ []string) (err error) {
 		return fmt.Errorf("unbind command requires --binding GUID, or $SB_BINDING")
 	}
 
-	broker := apiclient.NewOpenServiceBroker(
-		Opts.Broker.URLOpt,
-		Opts.Broker.ClientOpt,
-		Opts.Broker.ClientSecretOpt,
-		Opts.Broker.APIVersion,
-	)
+	broker, err := Opts.broker()
+	if err != nil {
+		return err
+	}
 	err = broker.Unbind(instance.ServiceID, instance.PlanID, instance.ID, bindingID)
+	if apiclient.IsDryRun(err) {
+		return nil
+	}
 	if err != nil {
 		return errwrap.Wrapf("Failed to unbind to service instance {{err}}", err)
 	}
-	Opts.config().UnbindServiceInstance(instance.ID, bindingID)
+	if err = Opts.config().UnbindServiceInstance(instance.ID, bindingID); err != nil {
+		return errwrap.Wrapf("Failed to remove binding from store {{err}}", err)
+	}
+
+	result := unbindResult{InstanceID: instance.ID, InstanceName: instance.Name, BindingID: bindingID}
+	return render(result, func() error {
+		fmt.Println("Success")
+		return nil
+	})
+}
 
-	fmt.Println("Success")
-	return
+type unbindResult struct {
+	InstanceID   string `json:"instance_id"`
+	InstanceName string `json:"instance_name"`
+	BindingID    string `json:"binding_id"`
 }
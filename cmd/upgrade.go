@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jhunt/go-table"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/starkandwayne/eden/apiclient"
+	edenstore "github.com/starkandwayne/eden/store"
+)
+
+// UpgradeOpts represents the 'upgrade' command
+type UpgradeOpts struct {
+	All bool `long:"all" description:"Upgrade every service instance of the target broker whose plan has a newer maintenance_info version"`
+	Yes bool `short:"y" long:"yes" description:"Do not ask for confirmation (with --all)"`
+}
+
+// upgradeResult is the outcome of upgrading one instance
+type upgradeResult struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	ServiceName string `json:"service_name"`
+	PlanName    string `json:"plan_name"`
+	From        string `json:"from_version,omitempty"`
+	To          string `json:"to_version,omitempty"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c UpgradeOpts) Execute(_ []string) (err error) {
+	instanceNameOrID := Opts.Instance.NameOrID
+	if (instanceNameOrID == "") == !c.All {
+		return fmt.Errorf("upgrade command requires either --instance [NAME|GUID] or --all")
+	}
+	instances, err := c.instances(instanceNameOrID)
+	if err != nil {
+		return err
+	}
+
+	results := []*upgradeResult{}
+	failed := 0
+	var broker *apiclient.OpenServiceBroker
+	if len(instances) > 0 {
+		if broker, err = Opts.broker(); err != nil {
+			return err
+		}
+	}
+	if c.All && !c.Yes && Opts.DryRun == "" && isTerminal(os.Stdin) {
+		if err = c.confirm(broker, instances); err != nil {
+			return err
+		}
+	}
+	for _, inst := range instances {
+		result := &upgradeResult{ID: inst.ID, Name: inst.Name, ServiceName: inst.ServiceName, PlanName: inst.PlanName}
+		if inst.MaintenanceInfo != nil {
+			result.From = inst.MaintenanceInfo.Version
+		}
+		results = append(results, result)
+		if err = c.upgrade(broker, inst, result); err != nil {
+			result.Status = "failed"
+			result.Error = err.Error()
+			progress("upgrade: %s failed: %s\n", inst.Name, err)
+			failed++
+		}
+	}
+
+	err = render(results, func() error {
+		table := table.NewTable("Name", "Service/Plan", "From", "To", "Status")
+		for _, result := range results {
+			status := result.Status
+			if result.Error != "" {
+				status = fmt.Sprintf("%s: %s", result.Status, result.Error)
+			}
+			table.Row(nil, result.Name, result.ServiceName+"/"+result.PlanName, result.From, result.To, status)
+		}
+		table.Output(os.Stdout)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("upgrade: %d of %d instances failed", failed, len(results))
+	}
+	return nil
+}
+
+// instances returns the instance to upgrade, or with --all, those of the
+// target broker, as its credentials are not sent to other brokers
+func (c UpgradeOpts) instances(instanceNameOrID string) ([]*edenstore.FSServiceInstance, error) {
+	if !c.All {
+		inst := Opts.config().FindServiceInstance(instanceNameOrID)
+		if inst.ServiceID == "" {
+			return nil, fmt.Errorf("upgrade --instance '%s' was not found", instanceNameOrID)
+		}
+		if !Opts.isTargetBroker(inst.BrokerURL) {
+			return nil, fmt.Errorf("upgrade: '%s' is an instance of %s; target it with --url to upgrade it", inst.Name, inst.BrokerURL)
+		}
+		return []*edenstore.FSServiceInstance{&inst}, nil
+	}
+	instances := []*edenstore.FSServiceInstance{}
+	others := 0
+	for _, inst := range Opts.config().ServiceInstances() {
+		if Opts.isTargetBroker(inst.BrokerURL) {
+			instances = append(instances, inst)
+		} else {
+			others++
+		}
+	}
+	if others > 0 {
+		progress("upgrade: skipping %d instance(s) of other brokers; target them with --url to upgrade them\n", others)
+	}
+	return instances, nil
+}
+
+// confirm asks whether to upgrade the instances that have a newer version
+func (c UpgradeOpts) confirm(broker *apiclient.OpenServiceBroker, instances []*edenstore.FSServiceInstance) error {
+	pending := []string{}
+	for _, inst := range instances {
+		available, err := availableUpgrade(broker, inst)
+		if err != nil || available == nil {
+			continue
+		}
+		from := "n/a"
+		if inst.MaintenanceInfo != nil {
+			from = inst.MaintenanceInfo.Version
+		}
+		pending = append(pending, fmt.Sprintf("%s (%s -> %s)", inst.Name, from, available.Version))
+	}
+	if len(pending) > 0 && !confirm(fmt.Sprintf("Upgrade %d instance(s): %s?", len(pending), strings.Join(pending, ", "))) {
+		return fmt.Errorf("upgrade: cancelled")
+	}
+	return nil
+}
+
+// upgrade updates an instance to the maintenance_info of its plan, if newer,
+// and waits for the broker to finish
+func (c UpgradeOpts) upgrade(broker *apiclient.OpenServiceBroker, inst *edenstore.FSServiceInstance, result *upgradeResult) error {
+	if !broker.Supports(apiclient.FeatureMaintenanceInfo) {
+		result.Status = fmt.Sprintf("not supported by OSB API %s", broker.APIVersion())
+		return nil
+	}
+	available, err := availableUpgrade(broker, inst)
+	if err != nil {
+		return err
+	}
+	if available == nil {
+		result.Status = "up-to-date"
+		return nil
+	}
+	result.To = available.Version
+
+	var previous *apiclient.MaintenanceInfo
+	if inst.MaintenanceInfo != nil {
+		previous = &apiclient.MaintenanceInfo{Version: inst.MaintenanceInfo.Version}
+	}
+	progress("upgrade: %s/%s - name: %s, from '%s' to '%s'\n", inst.ServiceName, inst.PlanName, inst.Name, result.From, result.To)
+	resp, isAsync, err := broker.Upgrade(inst.ServiceID, inst.PlanID, inst.ID, available, previous)
+	if apiclient.IsDryRun(err) {
+		result.Status = "not sent"
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if isAsync {
+		if err = Opts.config().RecordLastOperation(inst.ID, "upgrade", string(brokerapi.InProgress), ""); err != nil {
+			return err
+		}
+		lastOpResp, err := waitForLastOperation(broker, "update", inst.ServiceID, inst.PlanID, inst.ID, resp.OperationData,
+			func(lastOpResp *brokerapi.LastOperationResponse) {
+				progress("upgrade: %s - %s %s\n", inst.Name, lastOpResp.State, lastOpResp.Description)
+			})
+		if err != nil {
+			return err
+		}
+		if err = Opts.config().RecordLastOperation(inst.ID, "upgrade", string(lastOpResp.State), lastOpResp.Description); err != nil {
+			return err
+		}
+		if err = lastOperationError(lastOpResp); err != nil {
+			return err
+		}
+	} else if err = Opts.config().RecordLastOperation(inst.ID, "upgrade", string(brokerapi.Succeeded), ""); err != nil {
+		return err
+	}
+	if err = Opts.config().RecordMaintenanceInfo(inst.ID, available.Version, available.Description); err != nil {
+		return err
+	}
+	result.Status = "upgraded"
+	return nil
+}
+
+// availableUpgrade returns the maintenance_info of the instance's plan if it
+// is newer than the version the instance is at, or nil
+func availableUpgrade(broker *apiclient.OpenServiceBroker, inst *edenstore.FSServiceInstance) (*apiclient.MaintenanceInfo, error) {
+	available, err := broker.PlanMaintenanceInfo(inst.PlanID)
+	if err != nil || available == nil {
+		return nil, err
+	}
+	current := ""
+	if inst.MaintenanceInfo != nil {
+		current = inst.MaintenanceInfo.Version
+	}
+	if !apiclient.NewerMaintenanceVersion(current, available.Version) {
+		return nil, nil
+	}
+	return available, nil
+}
+
+// availableUpgrades checks the instances of the target broker for a newer
+// maintenance_info version of their plan, fetching the catalog only once, and
+// returns the newer versions by instance ID
+func availableUpgrades(instances []*edenstore.FSServiceInstance) (map[string]*apiclient.MaintenanceInfo, error) {
+	upgrades := map[string]*apiclient.MaintenanceInfo{}
+	var broker *apiclient.OpenServiceBroker
+	for _, inst := range instances {
+		if !Opts.isTargetBroker(inst.BrokerURL) {
+			continue
+		}
+		if broker == nil {
+			var err error
+			if broker, err = Opts.broker(); err != nil {
+				return upgrades, err
+			}
+			if _, err = broker.Catalog(); err != nil {
+				return upgrades, err
+			}
+		}
+		available, err := availableUpgrade(broker, inst)
+		if err != nil {
+			return upgrades, err
+		}
+		if available != nil {
+			upgrades[inst.ID] = available
+		}
+	}
+	return upgrades, nil
+}
+
+// recordMaintenanceInfo records the maintenance_info version of the plan that
+// an instance was provisioned with, if it has one
+func recordMaintenanceInfo(broker *apiclient.OpenServiceBroker, instanceID, planID string) error {
+	info, err := broker.PlanMaintenanceInfo(planID)
+	if err != nil || info == nil {
+		return err
+	}
+	return Opts.config().RecordMaintenanceInfo(instanceID, info.Version, info.Description)
+}
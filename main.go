@@ -15,7 +15,7 @@ func main() {
 	rand.Seed(5000)
 
 	if len(os.Args) > 1 {
-		if os.Args[1] == "-v" || os.Args[1] == "--version" {
+		if os.Args[1] == "--version" {
 			if Version == "" {
 				fmt.Printf("eden (development)\n")
 			} else {
@@ -26,6 +26,15 @@ func main() {
 	}
 
 	parser := flags.NewParser(&edencmd.Opts, flags.Default)
+	parser.CommandHandler = func(command flags.Commander, args []string) error {
+		if err := edencmd.Opts.ValidateOutput(); err != nil {
+			return err
+		}
+		if command == nil {
+			return nil
+		}
+		return command.Execute(args)
+	}
 
 	if len(os.Args) == 1 {
 		_, err := parser.ParseArgs([]string{"--help"})
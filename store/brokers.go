@@ -0,0 +1,69 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// FSBroker records what has been learned about a broker
+type FSBroker struct {
+	URL          string        `yaml:"url"            json:"url"`
+	APIVersion   string        `yaml:"api_version"    json:"api_version"`
+	NegotiatedAt time.Time     `yaml:"negotiated_at"  json:"negotiated_at"`
+	Auth         *FSBrokerAuth `yaml:"auth,omitempty" json:"auth,omitempty"`
+}
+
+// FSBrokerAuth records how to authenticate with a broker. Secrets are never
+// stored; SecretFile names the file holding the password, client secret or
+// bearer token.
+type FSBrokerAuth struct {
+	Type       string   `yaml:"type"                  json:"type"`
+	Client     string   `yaml:"client,omitempty"      json:"client,omitempty"`
+	TokenURL   string   `yaml:"token_url,omitempty"   json:"token_url,omitempty"`
+	Scopes     []string `yaml:"scopes,omitempty"      json:"scopes,omitempty"`
+	SecretFile string   `yaml:"secret_file,omitempty" json:"secret_file,omitempty"`
+}
+
+// FindBroker returns the record of the broker at url, which is empty if there is none
+func (c FSConfig) FindBroker(url string) FSBroker {
+	url = strings.TrimSuffix(url, "/")
+	for _, broker := range c.schema.Brokers {
+		if broker.URL == url {
+			return *broker
+		}
+	}
+	return FSBroker{}
+}
+
+// RecordAPIVersion remembers the API version negotiated with the broker at url
+func (c FSConfig) RecordAPIVersion(url, version string) error {
+	return c.updateBroker(url, func(broker *FSBroker) {
+		broker.APIVersion = version
+		broker.NegotiatedAt = time.Now()
+	})
+}
+
+// RecordBrokerAuth remembers how to authenticate with the broker at url, or
+// forgets it if auth is nil
+func (c FSConfig) RecordBrokerAuth(url string, auth *FSBrokerAuth) error {
+	return c.updateBroker(url, func(broker *FSBroker) {
+		broker.Auth = auth
+	})
+}
+
+// updateBroker changes the record of the broker at url, adding one if needed
+func (c FSConfig) updateBroker(url string, change func(broker *FSBroker)) error {
+	url = strings.TrimSuffix(url, "/")
+	return c.update(func(c *FSConfig) error {
+		for _, broker := range c.schema.Brokers {
+			if broker.URL == url {
+				change(broker)
+				return nil
+			}
+		}
+		broker := &FSBroker{URL: url}
+		change(broker)
+		c.schema.Brokers = append(c.schema.Brokers, broker)
+		return nil
+	})
+}
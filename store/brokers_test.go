@@ -0,0 +1,84 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestBrokerRecords(t *testing.T) {
+	oauth2 := &FSBrokerAuth{Type: "oauth2", Client: "client", TokenURL: "https://uaa/oauth/token", Scopes: []string{"broker.admin"}, SecretFile: "~/.secret"}
+	tests := []struct {
+		name        string
+		change      func(c FSConfig) error
+		find        string
+		wantVersion string
+		wantAuth    *FSBrokerAuth
+	}{
+		{
+			name:        "API version",
+			change:      func(c FSConfig) error { return c.RecordAPIVersion("http://broker", "2.15") },
+			find:        "http://broker",
+			wantVersion: "2.15",
+		},
+		{
+			name:        "trailing slash",
+			change:      func(c FSConfig) error { return c.RecordAPIVersion("http://broker/", "2.15") },
+			find:        "http://broker",
+			wantVersion: "2.15",
+		},
+		{
+			name:   "other broker",
+			change: func(c FSConfig) error { return c.RecordAPIVersion("http://other", "2.15") },
+			find:   "http://broker",
+		},
+		{
+			name:     "auth",
+			change:   func(c FSConfig) error { return c.RecordBrokerAuth("http://broker", oauth2) },
+			find:     "http://broker/",
+			wantAuth: oauth2,
+		},
+		{
+			name: "auth keeps the API version",
+			change: func(c FSConfig) error {
+				if err := c.RecordAPIVersion("http://broker", "2.16"); err != nil {
+					return err
+				}
+				return c.RecordBrokerAuth("http://broker", oauth2)
+			},
+			find:        "http://broker",
+			wantVersion: "2.16",
+			wantAuth:    oauth2,
+		},
+		{
+			name: "unset auth",
+			change: func(c FSConfig) error {
+				if err := c.RecordBrokerAuth("http://broker", oauth2); err != nil {
+					return err
+				}
+				return c.RecordBrokerAuth("http://broker", nil)
+			},
+			find: "http://broker",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, dir := testConfig(t)
+			defer os.RemoveAll(dir)
+			if err := test.change(config); err != nil {
+				t.Fatal(err)
+			}
+
+			broker := loadConfig(t, config.path).FindBroker(test.find)
+			if broker.APIVersion != test.wantVersion {
+				t.Errorf("APIVersion = %q, want %q", broker.APIVersion, test.wantVersion)
+			}
+			if !reflect.DeepEqual(broker.Auth, test.wantAuth) {
+				t.Errorf("Auth = %+v, want %+v", broker.Auth, test.wantAuth)
+			}
+			if n := len(loadConfig(t, config.path).schema.Brokers); n != 1 {
+				t.Errorf("%d broker records, want 1", n)
+			}
+		})
+	}
+}
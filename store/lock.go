@@ -0,0 +1,60 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// updateMutex serializes updates by goroutines; the lock file serializes
+// updates by concurrent eden processes
+var updateMutex sync.Mutex
+
+// update reloads the config from disk, applies change and saves it, all while
+// holding a lock, so that concurrent changes are not lost
+func (c *FSConfig) update(change func(c *FSConfig) error) error {
+	if c.readOnly {
+		return change(c)
+	}
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return bosherr.WrapErrorf(err, "Creating directory for config '%s'", c.path)
+	}
+	lock, err := os.OpenFile(c.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Opening lock file for config '%s'", c.path)
+	}
+	defer lock.Close()
+	if err = lockFile(lock); err != nil {
+		return bosherr.WrapErrorf(err, "Locking config '%s'", c.path)
+	}
+	defer unlockFile(lock)
+
+	if err = c.reload(); err != nil {
+		return err
+	}
+	if err = change(c); err != nil {
+		return err
+	}
+	return c.Save()
+}
+
+func (c *FSConfig) reload() error {
+	var schema FSServiceInstances
+	if c.fs.FileExists(c.path) {
+		bytes, err := c.fs.ReadFile(c.path)
+		if err != nil {
+			return bosherr.WrapErrorf(err, "Reading config '%s'", c.path)
+		}
+		if err = yaml.Unmarshal(bytes, &schema); err != nil {
+			return bosherr.WrapError(err, "Unmarshalling config")
+		}
+	}
+	c.schema = schema
+	return nil
+}
@@ -11,14 +11,16 @@ import (
 )
 
 type FSConfig struct {
-	path string
-	fs   boshsys.FileSystem
+	path     string
+	fs       boshsys.FileSystem
+	readOnly bool
 
 	schema FSServiceInstances
 }
 
 type FSServiceInstances struct {
 	ServiceInstances []*FSServiceInstance `yaml:"service_instances" json:"service_instances"`
+	Brokers          []*FSBroker          `yaml:"brokers,omitempty" json:"brokers,omitempty"`
 }
 
 type FSServiceInstance struct {
@@ -29,16 +31,70 @@ type FSServiceInstance struct {
 	PlanID      string             `yaml:"plan_id"      json:"plan_id"`
 	PlanName    string             `yaml:"plan_name"    json:"plan_name"`
 	BrokerURL   string             `yaml:"broker_url"   json:"broker_url"`
-	Bindings    []fsServiceBinding `yaml:"bindings"     json:"bindings"`
+	Parameters  string             `yaml:"parameters,omitempty" json:"parameters,omitempty"`
+	Labels      map[string]string  `yaml:"labels,omitempty" json:"labels,omitempty"`
+	Protected   bool               `yaml:"protected,omitempty" json:"protected,omitempty"`
+	Bindings    []FSServiceBinding `yaml:"bindings"     json:"bindings"`
 	CreatedAt   time.Time          `yaml:"created_at"   json:"created_at"`
+	Rotations   []FSRotation       `yaml:"rotations,omitempty" json:"rotations,omitempty"`
+
+	LastOperation   *FSLastOperation   `yaml:"last_operation,omitempty" json:"last_operation,omitempty"`
+	MaintenanceInfo *FSMaintenanceInfo `yaml:"maintenance_info,omitempty" json:"maintenance_info,omitempty"`
+}
+
+// FSLastOperation records the outcome of the latest broker operation on an instance
+type FSLastOperation struct {
+	Type        string    `yaml:"type"                  json:"type"`
+	State       string    `yaml:"state"                 json:"state"`
+	Description string    `yaml:"description,omitempty" json:"description,omitempty"`
+	UpdatedAt   time.Time `yaml:"updated_at"            json:"updated_at"`
+}
+
+// FSMaintenanceInfo records the maintenance_info version an instance was provisioned or upgraded to
+type FSMaintenanceInfo struct {
+	Version     string `yaml:"version"               json:"version"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+}
+
+// FSRotation records a credential rotation of a service instance
+type FSRotation struct {
+	NewBindingID       string    `yaml:"new_binding_id"                json:"new_binding_id"`
+	RetiredBindingIDs  []string  `yaml:"retired_binding_ids,omitempty" json:"retired_binding_ids,omitempty"`
+	RetainedBindingIDs []string  `yaml:"retained_binding_ids,omitempty" json:"retained_binding_ids,omitempty"`
+	Status             string    `yaml:"status"                        json:"status"`
+	RotatedAt          time.Time `yaml:"rotated_at"                    json:"rotated_at"`
+}
+
+// FSServiceBinding represents a binding with credentials
+type FSServiceBinding struct {
+	ID               string    `yaml:"id"             json:"id"`
+	Name             string    `yaml:"name"           json:"name"`
+	Credentials      string    `yaml:"credentials"    json:"credentials"`
+	CreatedAt        time.Time `yaml:"created_at"     json:"created_at"`
+	FSBindingDetails `yaml:",inline"`
+}
+
+// FSBindingDetails are the fields of a binding response other than credentials
+type FSBindingDetails struct {
+	SyslogDrainURL  string       `yaml:"syslog_drain_url,omitempty"  json:"syslog_drain_url,omitempty"`
+	RouteServiceURL string       `yaml:"route_service_url,omitempty" json:"route_service_url,omitempty"`
+	VolumeMounts    string       `yaml:"volume_mounts,omitempty"     json:"volume_mounts,omitempty"`
+	Endpoints       []FSEndpoint `yaml:"endpoints,omitempty"         json:"endpoints,omitempty"`
+	ExpiresAt       *time.Time   `yaml:"expires_at,omitempty"        json:"expires_at,omitempty"`
+	RenewBefore     *time.Time   `yaml:"renew_before,omitempty"      json:"renew_before,omitempty"`
 }
 
-// ServiceBinding represents a binding with credentials
-type fsServiceBinding struct {
-	ID          string    `yaml:"id"             json:"id"`
-	Name        string    `yaml:"name"           json:"name"`
-	Credentials string    `yaml:"credentials"    json:"credentials"`
-	CreatedAt   time.Time `yaml:"created_at"     json:"created_at"`
+// FSEndpoint is a network endpoint of a binding
+type FSEndpoint struct {
+	Host     string   `yaml:"host"               json:"host"`
+	Ports    []string `yaml:"ports"              json:"ports"`
+	Protocol string   `yaml:"protocol,omitempty" json:"protocol,omitempty"`
+}
+
+// RenewalDue returns true if the binding expires, or should be renewed, before the given time
+func (b FSServiceBinding) RenewalDue(before time.Time) bool {
+	return (b.ExpiresAt != nil && b.ExpiresAt.Before(before)) ||
+		(b.RenewBefore != nil && b.RenewBefore.Before(before))
 }
 
 func NewFSConfigFromPath(path string, fs boshsys.FileSystem) (FSConfig, error) {
@@ -64,14 +120,77 @@ func NewFSConfigFromPath(path string, fs boshsys.FileSystem) (FSConfig, error) {
 }
 
 // ProvisionNewServiceInstance initialize new FSServiceInstance
-func (c FSConfig) ProvisionNewServiceInstance(id, name, serviceID, serviceName, planID, planName, brokerURL string) {
-	_, inst := c.findOrCreateServiceInstanceByIDOrName(id, name)
-	inst.ServiceID = serviceID
-	inst.ServiceName = serviceName
-	inst.PlanID = planID
-	inst.PlanName = planName
-	inst.BrokerURL = brokerURL
-	c.Save()
+func (c FSConfig) ProvisionNewServiceInstance(id, name, serviceID, serviceName, planID, planName, brokerURL string) error {
+	return c.update(func(c *FSConfig) error {
+		_, inst := c.findOrCreateServiceInstanceByIDOrName(id, name)
+		inst.ServiceID = serviceID
+		inst.ServiceName = serviceName
+		inst.PlanID = planID
+		inst.PlanName = planName
+		inst.BrokerURL = brokerURL
+		return nil
+	})
+}
+
+// UpdateServiceInstance records the new plan and parameters of a service instance;
+// parameters should have their secrets redacted (see apiclient.RedactParameters)
+func (c FSConfig) UpdateServiceInstance(idOrName, planID, planName string, parameters json.RawMessage) error {
+	return c.update(func(c *FSConfig) error {
+		_, inst := c.findOrCreateServiceInstance(idOrName)
+		inst.PlanID = planID
+		inst.PlanName = planName
+		inst.Parameters = string(parameters)
+		return nil
+	})
+}
+
+// RecordLastOperation records the state of the latest operation on an instance
+func (c FSConfig) RecordLastOperation(idOrName, operationType, state, description string) error {
+	return c.update(func(c *FSConfig) error {
+		_, inst := c.findOrCreateServiceInstance(idOrName)
+		inst.LastOperation = &FSLastOperation{
+			Type:        operationType,
+			State:       state,
+			Description: description,
+			UpdatedAt:   time.Now(),
+		}
+		return nil
+	})
+}
+
+// RecordMaintenanceInfo records the maintenance_info version of an instance
+func (c FSConfig) RecordMaintenanceInfo(idOrName, version, description string) error {
+	return c.update(func(c *FSConfig) error {
+		_, inst := c.findOrCreateServiceInstance(idOrName)
+		inst.MaintenanceInfo = &FSMaintenanceInfo{Version: version, Description: description}
+		return nil
+	})
+}
+
+// SetProtected marks an instance as protected from deprovisioning, or not
+func (c FSConfig) SetProtected(idOrName string, protected bool) error {
+	return c.update(func(c *FSConfig) error {
+		_, inst := c.findOrCreateServiceInstance(idOrName)
+		inst.Protected = protected
+		return nil
+	})
+}
+
+// SetLabels adds or replaces the labels in set, and removes the labels in remove
+func (c FSConfig) SetLabels(idOrName string, set map[string]string, remove []string) error {
+	return c.update(func(c *FSConfig) error {
+		_, inst := c.findOrCreateServiceInstance(idOrName)
+		if inst.Labels == nil {
+			inst.Labels = map[string]string{}
+		}
+		for key, value := range set {
+			inst.Labels[key] = value
+		}
+		for _, key := range remove {
+			delete(inst.Labels, key)
+		}
+		return nil
+	})
 }
 
 // FindServiceInstance returns a copy of a service instance record
@@ -90,54 +209,71 @@ func (inst FSServiceInstance) FindServiceBinding(idOrName string) int {
 }
 
 // RenameServiceInstance updates the .Name of a service instance
-func (c FSConfig) RenameServiceInstance(idOrName, newName string) {
-	_, inst := c.findOrCreateServiceInstance(idOrName)
-	inst.Name = newName
-	c.Save()
+func (c FSConfig) RenameServiceInstance(idOrName, newName string) error {
+	return c.update(func(c *FSConfig) error {
+		_, inst := c.findOrCreateServiceInstance(idOrName)
+		inst.Name = newName
+		return nil
+	})
 }
 
 // BindServiceInstance records a new bindingID
-func (c FSConfig) BindServiceInstance(instanceID, bindingID, name string, rawCredentials interface{}) (err error) {
-	_, inst := c.findOrCreateServiceInstance(instanceID)
-
+func (c FSConfig) BindServiceInstance(instanceID, bindingID, name string, rawCredentials interface{}, details FSBindingDetails) (err error) {
 	credentialsStr, err := json.Marshal(rawCredentials)
 	if err != nil {
 		return bosherr.WrapError(err, "Marshalling raw credentials")
 	}
 
-	binding := fsServiceBinding{
-		ID:          bindingID,
-		Name:        name,
-		Credentials: string(credentialsStr),
-		CreatedAt:   time.Now(),
+	binding := FSServiceBinding{
+		ID:               bindingID,
+		Name:             name,
+		Credentials:      string(credentialsStr),
+		CreatedAt:        time.Now(),
+		FSBindingDetails: details,
 	}
-	inst.Bindings = append(inst.Bindings, binding)
-	return c.Save()
+	return c.update(func(c *FSConfig) error {
+		_, inst := c.findOrCreateServiceInstance(instanceID)
+		inst.Bindings = append(inst.Bindings, binding)
+		return nil
+	})
+}
+
+// RecordRotation appends to the credential rotation history of an instance
+func (c FSConfig) RecordRotation(instanceID string, rotation FSRotation) error {
+	return c.update(func(c *FSConfig) error {
+		_, inst := c.findOrCreateServiceInstance(instanceID)
+		inst.Rotations = append(inst.Rotations, rotation)
+		return nil
+	})
 }
 
 // UnbindServiceInstance removes record of a binding
-func (c FSConfig) UnbindServiceInstance(instanceID, bindingNameOrID string) {
-	_, inst := c.findOrCreateServiceInstance(instanceID)
-	bindings := []fsServiceBinding{}
-	for _, binding := range inst.Bindings {
-		if binding.ID != bindingNameOrID && binding.Name != bindingNameOrID {
-			bindings = append(bindings, binding)
+func (c FSConfig) UnbindServiceInstance(instanceID, bindingNameOrID string) error {
+	return c.update(func(c *FSConfig) error {
+		_, inst := c.findOrCreateServiceInstance(instanceID)
+		bindings := []FSServiceBinding{}
+		for _, binding := range inst.Bindings {
+			if binding.ID != bindingNameOrID && binding.Name != bindingNameOrID {
+				bindings = append(bindings, binding)
+			}
 		}
-	}
-	inst.Bindings = bindings
-	c.Save()
+		inst.Bindings = bindings
+		return nil
+	})
 }
 
 // DeprovisionServiceInstance removes record of an instance
-func (c FSConfig) DeprovisionServiceInstance(instanceNameOrID string) {
-	instances := []*FSServiceInstance{}
-	for _, instance := range c.schema.ServiceInstances {
-		if instance.ID != instanceNameOrID && instance.Name != instanceNameOrID {
-			instances = append(instances, instance)
+func (c FSConfig) DeprovisionServiceInstance(instanceNameOrID string) error {
+	return c.update(func(c *FSConfig) error {
+		instances := []*FSServiceInstance{}
+		for _, instance := range c.schema.ServiceInstances {
+			if instance.ID != instanceNameOrID && instance.Name != instanceNameOrID {
+				instances = append(instances, instance)
+			}
 		}
-	}
-	c.schema.ServiceInstances = instances
-	c.Save()
+		c.schema.ServiceInstances = instances
+		return nil
+	})
 }
 
 // ServiceInstances returns the list of service instances created locally
@@ -145,19 +281,34 @@ func (c FSConfig) ServiceInstances() []*FSServiceInstance {
 	return c.schema.ServiceInstances
 }
 
+// SetReadOnly makes changes apply only in memory, never saving them to file
+func (c *FSConfig) SetReadOnly() {
+	c.readOnly = true
+}
+
 // Save configuration/data to file
 func (c FSConfig) Save() error {
+	if c.readOnly {
+		return nil
+	}
 	bytes, err := yaml.Marshal(c.schema)
 	if err != nil {
 		return bosherr.WrapError(err, "Marshalling config")
 	}
 
-	err = c.fs.WriteFile(c.path, bytes)
+	// write then rename, so that readers never see a partially written config
+	tmpPath := c.path + ".tmp"
+	err = c.fs.WriteFile(tmpPath, bytes)
 	if err != nil {
 		return bosherr.WrapErrorf(err, "Writing config '%s'", c.path)
 	}
 
-	os.Chmod(c.path, 0600)
+	os.Chmod(tmpPath, 0600)
+
+	err = c.fs.Rename(tmpPath, c.path)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Writing config '%s'", c.path)
+	}
 
 	return nil
 }
@@ -206,12 +357,12 @@ func (c FSConfig) deepCopy() FSConfig {
 		panic("deserializing config schema")
 	}
 
-	return FSConfig{path: c.path, fs: c.fs, schema: schema}
+	return FSConfig{path: c.path, fs: c.fs, readOnly: c.readOnly, schema: schema}
 }
 
 // Credentials fixes any map[interface{}]interface{} into map[string]interface{}
 // as expected by JSON marshalling
-func (b fsServiceBinding) CredentialsJSON() (out map[string]interface{}, err error) {
+func (b FSServiceBinding) CredentialsJSON() (out map[string]interface{}, err error) {
 
 	err = json.Unmarshal([]byte(b.Credentials), &out)
 	if err != nil {
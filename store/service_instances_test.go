@@ -0,0 +1,253 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+)
+
+// testConfig returns a config in a new directory for the test to remove
+func testConfig(t *testing.T) (FSConfig, string) {
+	dir, err := ioutil.TempDir("", "eden-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loadConfig(t, filepath.Join(dir, "config")), dir
+}
+
+// loadConfig reads the config file at path, as a new eden command would
+func loadConfig(t *testing.T, path string) FSConfig {
+	config, err := NewFSConfigFromPath(path, boshsys.NewOsFileSystem(boshlog.NewLogger(boshlog.LevelNone)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	expiresAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name   string
+		change func(c FSConfig) error
+		check  func(inst FSServiceInstance) interface{}
+		want   interface{}
+	}{
+		{
+			name: "plan and parameters",
+			change: func(c FSConfig) error {
+				return c.UpdateServiceInstance("db", "large-id", "large", []byte(`{"size":2}`))
+			},
+			check: func(inst FSServiceInstance) interface{} { return []string{inst.PlanID, inst.PlanName, inst.Parameters} },
+			want:  []string{"large-id", "large", `{"size":2}`},
+		},
+		{
+			name:   "last operation",
+			change: func(c FSConfig) error { return c.RecordLastOperation("db", "provision", "failed", "out of disk") },
+			check: func(inst FSServiceInstance) interface{} {
+				return []string{inst.LastOperation.Type, inst.LastOperation.State, inst.LastOperation.Description}
+			},
+			want: []string{"provision", "failed", "out of disk"},
+		},
+		{
+			name:   "maintenance info",
+			change: func(c FSConfig) error { return c.RecordMaintenanceInfo("db", "2.0.0", "new") },
+			check:  func(inst FSServiceInstance) interface{} { return *inst.MaintenanceInfo },
+			want:   FSMaintenanceInfo{Version: "2.0.0", Description: "new"},
+		},
+		{
+			name: "labels",
+			change: func(c FSConfig) error {
+				return c.SetLabels("db", map[string]string{"env": "prod", "team": "a"}, []string{"team"})
+			},
+			check: func(inst FSServiceInstance) interface{} { return inst.Labels },
+			want:  map[string]string{"env": "prod"},
+		},
+		{
+			name:   "protected",
+			change: func(c FSConfig) error { return c.SetProtected("db", true) },
+			check:  func(inst FSServiceInstance) interface{} { return inst.Protected },
+			want:   true,
+		},
+		{
+			name:   "rename",
+			change: func(c FSConfig) error { return c.RenameServiceInstance("db", "db2") },
+			check:  func(inst FSServiceInstance) interface{} { return inst.Name },
+			want:   "db2",
+		},
+		{
+			name: "binding",
+			change: func(c FSConfig) error {
+				return c.BindServiceInstance("db", "binding-id", "app", map[string]interface{}{"password": "s3cret"}, FSBindingDetails{
+					SyslogDrainURL: "syslog://logs",
+					Endpoints:      []FSEndpoint{{Host: "10.0.0.1", Ports: []string{"5432"}}},
+					ExpiresAt:      &expiresAt,
+				})
+			},
+			check: func(inst FSServiceInstance) interface{} {
+				binding := inst.Bindings[inst.FindServiceBinding("app")]
+				return []interface{}{binding.ID, binding.Credentials, binding.SyslogDrainURL, binding.Endpoints, binding.ExpiresAt.UTC()}
+			},
+			want: []interface{}{"binding-id", `{"password":"s3cret"}`, "syslog://logs", []FSEndpoint{{Host: "10.0.0.1", Ports: []string{"5432"}}}, expiresAt},
+		},
+		{
+			name: "rotation",
+			change: func(c FSConfig) error {
+				return c.RecordRotation("db", FSRotation{NewBindingID: "new", RetiredBindingIDs: []string{"old"}, Status: "rotated", RotatedAt: expiresAt})
+			},
+			check: func(inst FSServiceInstance) interface{} {
+				rotation := inst.Rotations[0]
+				rotation.RotatedAt = rotation.RotatedAt.UTC()
+				return rotation
+			},
+			want: FSRotation{NewBindingID: "new", RetiredBindingIDs: []string{"old"}, Status: "rotated", RotatedAt: expiresAt},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, dir := testConfig(t)
+			defer os.RemoveAll(dir)
+			if err := config.ProvisionNewServiceInstance("instance-id", "db", "service-id", "postgres", "small-id", "small", "http://broker"); err != nil {
+				t.Fatal(err)
+			}
+			if err := test.change(loadConfig(t, config.path)); err != nil {
+				t.Fatal(err)
+			}
+
+			inst := loadConfig(t, config.path).FindServiceInstance("instance-id")
+			if inst.ServiceName != "postgres" || inst.BrokerURL != "http://broker" {
+				t.Errorf("instance record lost its service or broker: %+v", inst)
+			}
+			if got := test.check(inst); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("after reload got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRemoveRecords(t *testing.T) {
+	tests := []struct {
+		name          string
+		change        func(c FSConfig) error
+		wantInstances int
+		wantBindings  int
+	}{
+		{"unbind by name", func(c FSConfig) error { return c.UnbindServiceInstance("db", "app") }, 1, 0},
+		{"unbind by ID", func(c FSConfig) error { return c.UnbindServiceInstance("instance-id", "binding-id") }, 1, 0},
+		{"unbind unknown binding", func(c FSConfig) error { return c.UnbindServiceInstance("db", "other") }, 1, 1},
+		{"deprovision by name", func(c FSConfig) error { return c.DeprovisionServiceInstance("db") }, 0, 0},
+		{"deprovision by ID", func(c FSConfig) error { return c.DeprovisionServiceInstance("instance-id") }, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, dir := testConfig(t)
+			defer os.RemoveAll(dir)
+			if err := config.ProvisionNewServiceInstance("instance-id", "db", "service-id", "postgres", "small-id", "small", ""); err != nil {
+				t.Fatal(err)
+			}
+			if err := config.BindServiceInstance("instance-id", "binding-id", "app", map[string]string{}, FSBindingDetails{}); err != nil {
+				t.Fatal(err)
+			}
+			if err := test.change(loadConfig(t, config.path)); err != nil {
+				t.Fatal(err)
+			}
+
+			instances := loadConfig(t, config.path).ServiceInstances()
+			if len(instances) != test.wantInstances {
+				t.Fatalf("%d instances, want %d", len(instances), test.wantInstances)
+			}
+			if len(instances) > 0 && len(instances[0].Bindings) != test.wantBindings {
+				t.Errorf("%d bindings, want %d", len(instances[0].Bindings), test.wantBindings)
+			}
+		})
+	}
+}
+
+func TestReadOnlyConfigIsNotSaved(t *testing.T) {
+	config, dir := testConfig(t)
+	defer os.RemoveAll(dir)
+	config.SetReadOnly()
+	if err := config.ProvisionNewServiceInstance("instance-id", "db", "service-id", "postgres", "small-id", "small", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(config.path); !os.IsNotExist(err) {
+		t.Errorf("read-only config was saved: %v", err)
+	}
+}
+
+// concurrentUpdates is the number of instances each of the concurrent
+// goroutines or processes records
+const concurrentUpdates = 20
+
+func TestConcurrentUpdates(t *testing.T) {
+	config, dir := testConfig(t)
+	defer os.RemoveAll(dir)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		// each goroutine has its own copy of the config, as each command does
+		config := loadConfig(t, config.path)
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			if err := provisionMany(config, fmt.Sprintf("goroutine-%d", worker)); err != nil {
+				t.Error(err)
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	if got := len(loadConfig(t, config.path).ServiceInstances()); got != 4*concurrentUpdates {
+		t.Errorf("%d instances recorded, want %d", got, 4*concurrentUpdates)
+	}
+}
+
+// TestConcurrentProcesses runs this test binary as several eden processes,
+// which only the lock file keeps from losing each other's changes
+func TestConcurrentProcesses(t *testing.T) {
+	if path := os.Getenv("EDEN_TEST_CONFIG"); path != "" {
+		if err := provisionMany(loadConfig(t, path), os.Getenv("EDEN_TEST_WORKER")); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	config, dir := testConfig(t)
+	defer os.RemoveAll(dir)
+
+	processes := []*exec.Cmd{}
+	for worker := 0; worker < 4; worker++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestConcurrentProcesses$")
+		cmd.Env = append(os.Environ(), "EDEN_TEST_CONFIG="+config.path, fmt.Sprintf("EDEN_TEST_WORKER=process-%d", worker))
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		processes = append(processes, cmd)
+	}
+	for _, cmd := range processes {
+		if err := cmd.Wait(); err != nil {
+			t.Errorf("worker process failed: %s", err)
+		}
+	}
+
+	if got := len(loadConfig(t, config.path).ServiceInstances()); got != 4*concurrentUpdates {
+		t.Errorf("%d instances recorded, want %d", got, 4*concurrentUpdates)
+	}
+}
+
+func provisionMany(config FSConfig, worker string) error {
+	for i := 0; i < concurrentUpdates; i++ {
+		name := fmt.Sprintf("%s-%d", worker, i)
+		if err := config.ProvisionNewServiceInstance(name+"-id", name, "service-id", "postgres", "small-id", "small", ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}